@@ -0,0 +1,136 @@
+package bptree
+
+import (
+	"errors"
+)
+
+var (
+	// errors
+	ERR_NOT_AGGREGATED = errors.New("tree is not aggregated")
+)
+
+// Monoid aggregates values of elements, e.g. sum of sizes. Combine must be
+// associative with Identity as its identity, and must not modify its
+// arguments since aggregates of nodes are shared. Combine is applied in order
+// of keys, so it doesn't need to be commutative.
+type Monoid struct {
+	Identity interface{}
+	Combine  func(a, b interface{}) interface{}
+	Value    func(elem Elem) interface{} // of an element
+}
+
+// WithAggregate makes every node keep aggregate of elements in its sub-tree
+// by monoid, to be queried by Aggregate.
+func WithAggregate(monoid Monoid) Option {
+	return func(c *config) {
+		c.monoid = &monoid
+	}
+}
+
+// SetAggregate makes every node keep aggregate of elements in its sub-tree
+// by monoid, computing them for existing elements right away. nil monoid stops
+// aggregating.
+func (tree *Bptree) SetAggregate(monoid *Monoid) (err error) {
+	if monoid != nil && (monoid.Combine == nil || monoid.Value == nil) {
+		return errors.New("combine and value functions must be specified")
+	}
+
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated("set aggregate", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	tree.monoid = monoid
+	tree.monoids = monoidsOf(monoid, tree.intervals)
+
+	levels := tree.levels()
+
+	// from leaves up to root
+	for depth := len(levels) - 1; depth >= 0; depth-- {
+		for _, node := range levels[depth] {
+			node.aggs = nil
+
+			if tree.monoids != nil {
+				tree.reaggregate(node)
+			}
+		}
+	}
+
+	return nil
+}
+
+// monoidsOf returns monoids aggregating every sub-tree, nil if none
+func monoidsOf(monoid *Monoid, intervals bool) (monoids []*Monoid) {
+	if monoid != nil {
+		monoids = append(monoids, monoid)
+	}
+
+	if intervals {
+		monoids = append(monoids, maxEndMonoid)
+	}
+
+	return
+}
+
+// reaggregate combines aggregates of node out of its children
+func (tree *Bptree) reaggregate(node *indexNode) {
+	node.aggs = tree.aggregateOf(node)
+}
+
+func (tree *Bptree) aggregateOf(node *indexNode) []interface{} {
+	aggs := make([]interface{}, len(tree.monoids))
+
+	for k, monoid := range tree.monoids {
+		agg := monoid.Identity
+
+		for _, child := range node.children {
+			if c, ok := child.(*indexNode); ok {
+				agg = monoid.Combine(agg, c.aggs[k])
+			} else {
+				agg = monoid.Combine(agg, monoid.Value(unwrapElem(child)))
+			}
+		}
+
+		aggs[k] = agg
+	}
+
+	return aggs
+}
+
+// Aggregate returns aggregate of elements having keys in [lo, hi], nil for
+// unbounded, including expired ones not removed yet. It combines aggregates
+// of sub-trees entirely in the range, so it takes O(log n) combinations.
+func (tree *Bptree) Aggregate(lo, hi Key) (agg interface{}, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	defer tree.recovered("aggregate", &err)
+
+	monoid := tree.monoid
+	if monoid == nil {
+		return nil, ERR_NOT_AGGREGATED
+	}
+
+	agg = monoid.Identity
+
+	tree.visitRange(lo, hi, func(node *indexNode) {
+		agg = monoid.Combine(agg, node.aggs[0])
+	}, func(stored Elem) {
+		agg = monoid.Combine(agg, monoid.Value(unwrapElem(stored)))
+	})
+
+	return agg, nil
+}
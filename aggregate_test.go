@@ -0,0 +1,178 @@
+package bptree
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+var sumMonoid = Monoid{
+	Identity: 0,
+	Combine: func(a, b interface{}) interface{} {
+		return a.(int) + b.(int)
+	},
+	Value: func(elem Elem) interface{} {
+		return elem.(*testElem).val
+	},
+}
+
+// keys in order, to check combination keeps order
+var concatMonoid = Monoid{
+	Identity: []int(nil),
+	Combine: func(a, b interface{}) interface{} {
+		return append(append([]int(nil), a.([]int)...), b.([]int)...)
+	},
+	Value: func(elem Elem) interface{} {
+		return []int{elem.(*testElem).val}
+	},
+}
+
+func TestModelAggregated(t *testing.T) {
+	for _, maxDegree := range []int{3, 4, 7} {
+		for _, allowOverlap := range []bool{false, true} {
+			duplicates := DUPLICATE_REJECT
+			if allowOverlap {
+				duplicates = DUPLICATE_ALLOW
+			}
+
+			newTree := func() *Bptree {
+				tree, err := New(WithDegree(maxDegree), WithDuplicates(duplicates), WithAggregate(sumMonoid))
+				if err != nil {
+					t.Errorf("while creating bptree: %v", err)
+					t.FailNow()
+				}
+
+				return tree
+			}
+
+			r := rand.New(rand.NewSource(int64(maxDegree)))
+
+			ops := make([]modelOp, 1000)
+			for i := range ops {
+				ops[i] = randomModelOp(r, 200)
+			}
+
+			err := runModelTree(newTree(), ops)
+			if err == nil {
+				continue
+			}
+
+			ops = shrinkModelOps(ops, func(ops []modelOp) bool {
+				return runModelTree(newTree(), ops) != nil
+			})
+
+			t.Errorf("degree %d, overlap %v: %v\nminimal reproduction:\n%s",
+				maxDegree, allowOverlap, runModelTree(newTree(), ops), formatModelOps(ops))
+			t.FailNow()
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	tree, err := New(WithDegree(4), WithDuplicates(DUPLICATE_ALLOW), WithAggregate(concatMonoid))
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	counts := make([]int, 300)
+
+	for i := 0; i < 1000; i++ {
+		key := r.Intn(300)
+
+		if counts[key] > 0 && r.Intn(3) == 0 {
+			tree.Remove(testKey(key))
+			counts[key] -= 1
+		} else {
+			tree.Insert(&testElem{key})
+			counts[key] += 1
+		}
+	}
+
+	for round := 0; round < 200; round++ {
+		lo, hi := r.Intn(320)-10, r.Intn(320)-10
+
+		var loKey, hiKey Key = testKey(lo), testKey(hi)
+
+		switch round % 4 {
+		case 1:
+			loKey, lo = nil, 0
+		case 2:
+			hiKey, hi = nil, 299
+		}
+
+		var want []int
+		for key := lo; key <= hi; key++ {
+			for n := 0; key >= 0 && key < 300 && n < counts[key]; n++ {
+				want = append(want, key)
+			}
+		}
+
+		agg, err := tree.Aggregate(loKey, hiKey)
+		if err != nil {
+			t.Errorf("while aggregating: %v", err)
+			t.FailNow()
+		}
+
+		if got := agg.([]int); !equalInts(got, want) {
+			t.Errorf("aggregate of [%v, %v] is %v, expected %v", loKey, hiKey, got, want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSetAggregate(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 1; i <= 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	_, err = tree.Aggregate(nil, nil)
+	if !errors.Is(err, ERR_NOT_AGGREGATED) {
+		t.Errorf("tree not aggregated must fail with ERR_NOT_AGGREGATED, but %v", err)
+		t.FailNow()
+	}
+
+	err = tree.SetAggregate(&sumMonoid)
+	if err != nil {
+		t.Errorf("while setting aggregate: %v", err)
+		t.FailNow()
+	}
+
+	sum, _ := tree.Aggregate(testKey(11), testKey(20))
+	if sum != 155 {
+		t.Errorf("sum of 11..20 must be 155, but %v", sum)
+		t.FailNow()
+	}
+
+	sum, _ = tree.Aggregate(nil, nil)
+	if sum != 5050 {
+		t.Errorf("sum of all must be 5050, but %v", sum)
+		t.FailNow()
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.SetAggregate(nil)
+	if err != nil {
+		t.Errorf("while unsetting aggregate: %v", err)
+		t.FailNow()
+	}
+
+	_, err = tree.Aggregate(nil, nil)
+	if !errors.Is(err, ERR_NOT_AGGREGATED) {
+		t.Errorf("aggregate must be unset, but %v", err)
+		t.FailNow()
+	}
+}
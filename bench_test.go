@@ -0,0 +1,322 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+var (
+	benchDegrees = []int{4, 16, 64, 256}
+	benchSizes   = []int{1000, 100000}
+)
+
+// benchEach runs fn for every combination of degree and element count
+func benchEach(b *testing.B, fn func(b *testing.B, maxDegree, n int)) {
+	for _, maxDegree := range benchDegrees {
+		for _, n := range benchSizes {
+			b.Run(fmt.Sprintf("degree=%d/n=%d", maxDegree, n), func(b *testing.B) {
+				b.ReportAllocs()
+				fn(b, maxDegree, n)
+			})
+		}
+	}
+}
+
+// benchKeys returns 0, 2, 4, ... in order, so odd keys are never found
+func benchKeys(n int, order string) []int {
+	keys := make([]int, n)
+
+	for i := range keys {
+		keys[i] = i * 2
+	}
+
+	switch order {
+	case "random":
+		rand.New(rand.NewSource(int64(n))).Shuffle(n, func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+
+	case "reverse":
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	return keys
+}
+
+func newBenchTree(b *testing.B, maxDegree int, keys []int) *Bptree {
+	tree, err := NewBptree(maxDegree, _maxDepth*2, false)
+	if err != nil {
+		b.Fatalf("while creating bptree: %v", err)
+	}
+
+	for _, k := range keys {
+		err = tree.Insert(&testElem{k})
+		if err != nil {
+			b.Fatalf("while inserting %d: %v", k, err)
+		}
+	}
+
+	return tree
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, order := range []string{"sequential", "random", "reverse"} {
+		b.Run(order, func(b *testing.B) {
+			benchEach(b, func(b *testing.B, maxDegree, n int) {
+				keys := benchKeys(n, order)
+				elems := make([]*testElem, n)
+
+				for i, k := range keys {
+					elems[i] = &testElem{k}
+				}
+
+				var tree *Bptree
+
+				for i := 0; i < b.N; i++ {
+					// start over once the tree is filled up
+					if i%n == 0 {
+						b.StopTimer()
+						tree = newBenchTree(b, maxDegree, nil)
+						b.StartTimer()
+					}
+
+					tree.Insert(elems[i%n])
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, hit := range []bool{true, false} {
+		name := "hit"
+		if !hit {
+			name = "miss"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			benchEach(b, func(b *testing.B, maxDegree, n int) {
+				keys := benchKeys(n, "random")
+				tree := newBenchTree(b, maxDegree, keys)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					k := keys[i%n]
+					if !hit {
+						k += 1
+					}
+
+					_, ok, _ := tree.SearchElem(testKey(k))
+					if ok != hit {
+						b.Fatalf("search of %d must be %v", k, hit)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSearchNearby(b *testing.B) {
+	benchEach(b, func(b *testing.B, maxDegree, n int) {
+		keys := benchKeys(n, "random")
+		tree := newBenchTree(b, maxDegree, keys)
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			// odd keys are between elements
+			direction := Direction(ToRight)
+			if i%2 == 0 {
+				direction = ToLeft
+			}
+
+			tree.SearchElemNearby(testKey(keys[i%n]+1), direction)
+		}
+	})
+}
+
+func BenchmarkElemRange(b *testing.B) {
+	for _, length := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("length=%d", length), func(b *testing.B) {
+			benchEach(b, func(b *testing.B, maxDegree, n int) {
+				keys := benchKeys(n, "random")
+				tree := newBenchTree(b, maxDegree, keys)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					res, _, _ := tree.Search(testKey(keys[i%n]))
+					res.ElemRange(length)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkElemRangeTo(b *testing.B) {
+	for _, length := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("length=%d", length), func(b *testing.B) {
+			benchEach(b, func(b *testing.B, maxDegree, n int) {
+				keys := benchKeys(n, "random")
+				tree := newBenchTree(b, maxDegree, keys)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					k := keys[i%n]
+
+					res, _, _ := tree.Search(testKey(k))
+					res.ElemRangeTo(testKey(k+length*2), ToRight, length+1)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkRemove(b *testing.B) {
+	benchEach(b, func(b *testing.B, maxDegree, n int) {
+		keys := benchKeys(n, "random")
+
+		var tree *Bptree
+
+		for i := 0; i < b.N; i++ {
+			// refill once the tree is emptied
+			if i%n == 0 {
+				b.StopTimer()
+				tree = newBenchTree(b, maxDegree, keys)
+				b.StartTimer()
+			}
+
+			tree.Remove(testKey(keys[i%n]))
+		}
+	})
+}
+
+// BenchmarkParallelMixed runs searches with a write out of ten operations
+// from every goroutine
+func BenchmarkParallelMixed(b *testing.B) {
+	benchEach(b, func(b *testing.B, maxDegree, n int) {
+		keys := benchKeys(n, "random")
+		tree := newBenchTree(b, maxDegree, keys)
+
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			r := rand.New(rand.NewSource(rand.Int63()))
+
+			for pb.Next() {
+				k := r.Intn(n * 2)
+
+				switch r.Intn(10) {
+				case 0:
+					if k%2 == 0 {
+						tree.Remove(testKey(k))
+					} else {
+						tree.Insert(&testElem{k})
+					}
+
+				case 1:
+					res, _, err := tree.Search(testKey(k))
+					if err == nil && res != nil {
+						res.ElemRange(10)
+					}
+
+				default:
+					tree.SearchElem(testKey(k))
+				}
+			}
+		})
+	})
+}
+
+// baselines to compare with
+
+type benchSortedSlice []*testElem
+
+func (s benchSortedSlice) find(k int) (int, bool) {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].val >= k
+	})
+
+	return i, i < len(s) && s[i].val == k
+}
+
+func (s *benchSortedSlice) insert(elem *testElem) {
+	i, found := s.find(elem.val)
+	if found {
+		return
+	}
+
+	*s = append(*s, nil)
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = elem
+}
+
+func BenchmarkBaselineInsert(b *testing.B) {
+	for _, n := range benchSizes {
+		keys := benchKeys(n, "random")
+
+		b.Run(fmt.Sprintf("slice/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+
+			var s benchSortedSlice
+
+			for i := 0; i < b.N; i++ {
+				if i%n == 0 {
+					s = nil
+				}
+
+				s.insert(&testElem{keys[i%n]})
+			}
+		})
+
+		b.Run(fmt.Sprintf("map/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+
+			var m map[int]*testElem
+
+			for i := 0; i < b.N; i++ {
+				if i%n == 0 {
+					m = make(map[int]*testElem)
+				}
+
+				m[keys[i%n]] = &testElem{keys[i%n]}
+			}
+		})
+	}
+}
+
+func BenchmarkBaselineSearch(b *testing.B) {
+	for _, n := range benchSizes {
+		keys := benchKeys(n, "random")
+
+		s := make(benchSortedSlice, 0, n)
+		m := make(map[int]*testElem, n)
+
+		for _, k := range keys {
+			s.insert(&testElem{k})
+			m[k] = &testElem{k}
+		}
+
+		b.Run(fmt.Sprintf("slice/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				s.find(keys[i%n])
+			}
+		})
+
+		b.Run(fmt.Sprintf("map/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				_ = m[keys[i%n]]
+			}
+		})
+	}
+}
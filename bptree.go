@@ -1,10 +1,12 @@
 package bptree
 
 import (
+	"container/list"
 	"errors"
-	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Cond int
@@ -37,10 +39,63 @@ var (
 type Bptree struct {
 	root *indexNode
 
-	maxDegree int
-	maxDepth  int
+	// left-most and right-most leaves
+	head *indexNode
+	tail *indexNode
+
+	maxDegree    int // of internal nodes
+	leafCapacity int
+	maxDepth     int // negative for no cap
 
 	allowOverlap bool
+	fillFactor   float64 // of bulk loading
+
+	codec   Codec
+	hashing bool    // keeps hash of every sub-tree
+	monoid  *Monoid // aggregates every sub-tree, nil if not
+
+	intervals bool // keeps max end of intervals in every sub-tree
+
+	// aggregates every sub-tree, monoid first if any and max end of
+	// intervals last if an interval tree
+	monoids []*Monoid
+
+	hooks hooks
+
+	// records every element stored into or removed from leaves for a
+	// Primary, nil if not replicated
+	journal func(op byte, stored Elem)
+
+	// time to live
+	clock          Clock
+	expiring       int   // number of elements inserted with ttl
+	nextDeadline   int64 // earliest deadline, zero if unknown
+	expireHooks    []ExpireHook
+	reaperStop     chan struct{}
+	reaperInterval time.Duration // of the running reaper
+
+	// capacity and eviction
+	count       int
+	bytes       int64
+	capacity    Capacity
+	recency     *list.List // front is the most recently accessed
+	recencyLock *sync.Mutex
+	evicted     uint64
+	evictHooks  []EvictHook
+
+	verifyOnMutation bool
+
+	observer Observer
+
+	// structural changes since creation
+	splits          uint64
+	merges          uint64
+	redistributions uint64
+
+	// corruption
+	recoverPanics bool
+	corruption    *ErrCorrupted // found during the mutation in progress
+	poisoned      atomic.Pointer[ErrCorrupted]
 
 	lock *sync.RWMutex
 
@@ -48,6 +103,16 @@ type Bptree struct {
 }
 
 func NewBptree(maxDegree, maxDepth int, allowOverlap bool) (*Bptree, error) {
+	opts, err := positionalOptions(maxDegree, maxDepth, allowOverlap)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(opts...)
+}
+
+// positionalOptions turns arguments of NewBptree into options of New
+func positionalOptions(maxDegree, maxDepth int, allowOverlap bool) ([]Option, error) {
 	if maxDegree < 3 {
 		return nil, errors.New("max degree must to have more than 3")
 	}
@@ -56,16 +121,15 @@ func NewBptree(maxDegree, maxDepth int, allowOverlap bool) (*Bptree, error) {
 		return nil, errors.New("max depth must to have zero or a positive value")
 	}
 
-	return &Bptree{
-		maxDegree:    maxDegree,
-		maxDepth:     maxDepth,
-		allowOverlap: allowOverlap,
-		lock:         new(sync.RWMutex),
-		initialized:  true,
-	}, nil
+	duplicates := DUPLICATE_REJECT
+	if allowOverlap {
+		duplicates = DUPLICATE_ALLOW
+	}
+
+	return []Option{WithDegree(maxDegree), WithMaxDepth(maxDepth), WithDuplicates(duplicates)}, nil
 }
 
-func (tree *Bptree) Insert(elem Elem) error {
+func (tree *Bptree) Insert(elem Elem) (err error) {
 	if !tree.initialized {
 		return ERR_NOT_INITIALIZED
 	}
@@ -74,10 +138,78 @@ func (tree *Bptree) Insert(elem Elem) error {
 	tree.lock.Lock()
 	defer tree.lock.Unlock()
 
+	if tree.observer != nil {
+		defer tree.observe(OP_INSERT, elem.Key(), time.Now(), &err)
+	}
+
+	defer tree.mutated("insert", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	err = tree.insertWithHooks(elem, elem)
+
+	return keyError("insert", elem.Key(), err)
+}
+
+// insertWithHooks inserts stored, which is elem itself or a wrapper of it,
+// caller must hold tree lock
+func (tree *Bptree) insertWithHooks(elem, stored Elem) error {
+	err := tree.hooks.run(tree.hooks.beforeInsert, elem)
+	if err != nil {
+		return err
+	}
+
+	err = tree.insert(tree.wrap(stored))
+	if err != nil {
+		return err
+	}
+
+	err = tree.hooks.run(tree.hooks.afterInsert, elem)
+	if err != nil {
+		// roll back, the element stays if it can't be taken out
+		e := tree.removeElem(elem)
+		if e != nil {
+			tree.corrupted(nil, "inserted element %v can't be taken out: %v", elem.Key(), e)
+		}
+
+		return err
+	}
+
+	tree.evict()
+
+	return nil
+}
+
+func (tree *Bptree) insert(elem Elem) error {
+	if tree.expiring > 0 && !tree.allowOverlap {
+		// expired element must not block inserting the same key
+		tree.expireKey(elem.Key())
+	}
+
+	err := tree.seal(elem)
+	if err != nil {
+		return err
+	}
+
+	err = tree.place(elem)
+	if err != nil {
+		return err
+	}
+
+	tree.account(elem, 1)
+
+	return nil
+}
+
+// place puts elem into a leaf and splits overflowed nodes
+func (tree *Bptree) place(elem Elem) error {
 	// create root node if it is not exist
 	if tree.root == nil {
 		rnode := &indexNode{
-			children:    make([]Elem, 0, tree.maxDegree+1),
+			children:    make([]Elem, 0, tree.maxChildren(false)+1),
 			depthToLeaf: 0,
 			isInternal:  false,
 			next:        nil,
@@ -86,12 +218,15 @@ func (tree *Bptree) Insert(elem Elem) error {
 
 		rnode.children = append(rnode.children, elem)
 
+		tree.refresh(rnode)
+
 		tree.root = rnode
+		tree.head, tree.tail = rnode, rnode
 		return nil
 	}
 
 	// check current node depth, actually tree could have tree.maxDepth + 1
-	if tree.root.depthToLeaf > tree.maxDepth {
+	if tree.maxDepth >= 0 && tree.root.depthToLeaf > tree.maxDepth {
 		return ERR_EXCEED_MAX_DEPTH
 	}
 
@@ -104,24 +239,17 @@ func (tree *Bptree) Insert(elem Elem) error {
 	// insert element into last index node
 	lastPath := paths[len(paths)-1]
 
-	err = lastPath.insertElem(elem, tree.maxDegree, tree.allowOverlap)
+	err = lastPath.insertElem(elem, tree.maxChildren(false), tree.allowOverlap)
 	if err != nil {
 		return err
 	}
 
-	// do balancing if index node has children more than tree.maxDegree
+	// do balancing if index node has children more than allowed
 	for i := len(paths) - 1; i >= 0; i-- {
 		path := paths[i]
+		tree.refresh(path)
 
-		var allowedDegree int
-
-		if path.isInternal {
-			allowedDegree = tree.maxDegree
-		} else {
-			allowedDegree = tree.maxDegree - 1
-		}
-
-		if len(path.children) > allowedDegree {
+		if len(path.children) > tree.maxChildren(path.isInternal) {
 			err = tree.balance(paths[:i+1])
 			if err != nil {
 				return err
@@ -132,7 +260,7 @@ func (tree *Bptree) Insert(elem Elem) error {
 	return nil
 }
 
-func (tree *Bptree) Remove(key Key) error {
+func (tree *Bptree) Remove(key Key) (err error) {
 	if !tree.initialized {
 		return ERR_NOT_INITIALIZED
 	}
@@ -141,68 +269,142 @@ func (tree *Bptree) Remove(key Key) error {
 	tree.lock.Lock()
 	defer tree.lock.Unlock()
 
-	// find paths
-	paths, err := tree.findToExactElem(key)
+	if tree.observer != nil {
+		defer tree.observe(OP_REMOVE, key, time.Now(), &err)
+	}
+
+	defer tree.mutated("remove", &err)
+
+	err = tree.writable()
 	if err != nil {
 		return err
 	}
 
-	lenPaths := len(paths)
+	// find paths
+	paths, idx, err := tree.findWhere(key, tree.visibleMatch(tree.now()))
+	if err != nil {
+		return keyError("remove", key, err)
+	}
+
+	stored := paths[len(paths)-1].children[idx]
+	elem := unwrapElem(stored)
+
+	err = tree.hooks.run(tree.hooks.beforeRemove, elem)
+	if err != nil {
+		return err
+	}
 
-	// if only root is existed
-	if lenPaths == 1 {
-		root := paths[0]
+	err = tree.removeAt(paths, idx)
+	if err != nil {
+		return err
+	}
 
-		ok := root.deleteElem(key, tree.maxDegree)
-		if !ok {
-			treePrinted, _ := printTreeToString(tree)
-			panic(fmt.Sprintf("element must be existed in root\n%s\n", treePrinted))
+	err = tree.hooks.run(tree.hooks.afterRemove, elem)
+	if err != nil {
+		// roll back, the element is lost if it can't be put back
+		e := tree.insert(stored)
+		if e != nil {
+			tree.corrupted(nil, "removed element %v can't be put back: %v", key, e)
 		}
 
-		return nil
+		return err
 	}
 
-	var allowedDegree int
-	var curr *indexNode
+	return nil
+}
 
-	// do balancing if index node has children less than tree.maxDegree / 2
-	for i := lenPaths - 1; i >= 0; i-- {
-		curr = paths[i]
+// removeElem removes the very element rather than any of equal key, caller
+// must hold tree lock. No hooks run.
+func (tree *Bptree) removeElem(elem Elem) error {
+	paths, idx, err := tree.findWhere(elem.Key(), sameStored(elem))
+	if err != nil {
+		return err
+	}
 
-		if i == 0 { // at root
-			if tree.root != curr {
-				panic("must should be root")
-			}
+	return tree.removeAt(paths, idx)
+}
 
-			if len(curr.children) == 0 {
-				if len(paths) > 1 {
-					tree.root = paths[1]
-				} else {
-					tree.root = nil
-				}
-			}
+// sameStored matches the stored element wrapping the very elem
+func sameStored(elem Elem) func(Elem) bool {
+	return func(stored Elem) bool {
+		return sameElem(unwrapElem(stored), elem)
+	}
+}
 
-			return nil
-		}
+// removeWhere removes the first element of key accepted by match, like Remove
+// does to the tree lock, poisoning and finishing the mutation. It returns the
+// stored element to be put back by restore. No hooks run.
+func (tree *Bptree) removeWhere(op string, key Key, match func(Elem) bool) (stored Elem, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
 
-		if curr.isInternal {
-			allowedDegree = tree.maxDegree / 2
-		} else {
-			allowedDegree = (tree.maxDegree - 1) / 2
-		}
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated(op, &err)
 
-		if i == lenPaths-1 { // at first loop (last node in paths)
-			// delete the element at belong node
-			var ok bool
-			ok = curr.deleteElem(key, tree.maxDegree)
-			if !ok {
-				treePrinted, _ := printTreeToString(tree)
-				panic(fmt.Sprintf("element must be existed\n%s\n", treePrinted))
-			}
-		}
+	err = tree.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, idx, err := tree.findWhere(key, match)
+	if err != nil {
+		return nil, keyError(op, key, err)
+	}
+
+	stored = paths[len(paths)-1].children[idx]
+
+	err = tree.removeAt(paths, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+// restore puts back stored element removed by removeWhere, like Insert does
+// to the tree lock, poisoning and finishing the mutation. No hooks run.
+func (tree *Bptree) restore(op string, stored Elem) (err error) {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated(op, &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	return keyError(op, stored.Key(), tree.insert(tree.wrap(stored)))
+}
+
+// removeAt deletes idx-th element of the leaf at the end of paths and
+// rebalances nodes along paths
+func (tree *Bptree) removeAt(paths []*indexNode, idx int) error {
+	lenPaths := len(paths)
+
+	elem := paths[lenPaths-1].deleteAt(idx, tree.maxChildren(false))
+
+	tree.account(elem, -1)
+
+	// do balancing if index node has children less than allowed, except root
+	for i := lenPaths - 1; i > 0; i-- {
+		curr := paths[i]
+		allowedDegree := tree.minChildren(curr.isInternal)
+
+		tree.refresh(curr)
 
 		if len(curr.children) < allowedDegree {
-			ok := tree.redistribution(paths[:i+1], allowedDegree)
+			ok, err := tree.redistribution(paths[:i+1], allowedDegree)
+			if err != nil {
+				return err
+			}
 
 			if !ok {
 				err = tree.merge(paths[:i+1])
@@ -213,6 +415,14 @@ func (tree *Bptree) Remove(key Key) error {
 		}
 	}
 
+	tree.refresh(paths[0])
+
+	// root having only one child is redundant
+	for tree.root.isInternal && len(tree.root.children) == 1 {
+		tree.root = tree.root.children[0].(*indexNode)
+		tree.changed(STRUCTURE_ROOT_SHRUNK, tree.root.depthToLeaf+1)
+	}
+
 	return nil
 }
 
@@ -251,58 +461,70 @@ func (tree *Bptree) SearchNearby(key Key, direction Direction) (res *SearchResul
 	// read lock
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
+	if tree.observer != nil {
+		defer tree.observe(OP_SEARCH_NEARBY, key, time.Now(), &err)
+	}
+
+	defer tree.recovered("search nearby", &err)
 
 	var elem Elem
+	var ok bool
 
 	// find paths
 	paths, _ := tree.findToExactElem(key)
 
 	if len(paths) == 0 {
-		err = ERR_EMPTY
+		err = keyError("search nearby", key, ERR_EMPTY)
 		return
 	}
 
+	now := tree.now()
 	node := paths[len(paths)-1]
 
 	i, equal := node.children.find(key)
-	if equal {
-		elem = node.children[i]
-	} else {
+
+	// expired elements of equal key are skipped
+	for ; equal && i < len(node.children); i++ {
+		if node.children[i].Key().CompareTo(key) != Equal {
+			equal = false
+			break
+		}
+
+		elem, ok = tree.visible(node.children[i], now)
+		if ok {
+			break
+		}
+	}
+
+	if !ok {
+		equal = false
+		res = &SearchResult{node: node, i: i, tree: tree}
+
 		switch direction {
 		case ToRight:
-			if i == len(node.children) {
-				if node.next == nil {
-					err = ERR_SEARCH_OVERFLOWED
-					return
-				}
-
-				elem = node.next.children[0]
-			} else {
-				elem = node.children[i]
+			// start from the element before i, so the step lands on i
+			node, i, elem = res.step(node, i-1, ToRight, now)
+			if node == nil {
+				err = keyError("search nearby", key, ERR_SEARCH_OVERFLOWED)
+				return
 			}
 
 		case ToLeft:
-			if i == 0 {
-				if node.prev == nil {
-					err = ERR_SEARCH_UNDERFLOWED
-					return
-				}
-
-				node = node.prev
-				i = len(node.children) - 1
-				elem = node.prev.children[i]
-			} else {
-				i -= 1
-				elem = node.children[i]
+			node, i, elem = res.step(node, i, ToLeft, now)
+			if node == nil {
+				err = keyError("search nearby", key, ERR_SEARCH_UNDERFLOWED)
+				return
 			}
 		}
 	}
 
+	tree.touch(node.children[i])
+
 	res = &SearchResult{
 		node:      node,
 		i:         i,
 		matchElem: elem,
-		treeLock:  tree.lock,
+		tree:      tree,
 	}
 
 	return
@@ -317,35 +539,42 @@ func (tree *Bptree) Search(key Key) (res *SearchResult, ok bool, err error) {
 	// read lock
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
+	if tree.observer != nil {
+		defer func(start time.Time) {
+			outcome := err
+			if outcome == nil && !ok {
+				outcome = ERR_NOT_FOUND
+			}
+
+			tree.observe(OP_SEARCH, key, start, &outcome)
+		}(time.Now())
+	}
+
+	defer tree.recovered("search", &err)
+
+	now := tree.now()
 
 	// find paths
-	paths, e := tree.findToExactElem(key)
+	paths, i, e := tree.findWhere(key, tree.visibleMatch(now))
 	if e != nil {
 		if e != ERR_NOT_FOUND {
-			err = e
+			err = keyError("search", key, e)
 			return
 		} else {
 			return
 		}
 	}
 
-	if len(paths) == 0 {
-		err = ERR_EMPTY
-		return
-	}
-
 	node := paths[len(paths)-1]
+	elem, _ := tree.visible(node.children[i], now)
 
-	i, equal := node.children.find(key)
-	if !equal {
-		return
-	}
+	tree.touch(node.children[i])
 
 	res = &SearchResult{
 		node:      node,
 		i:         i,
-		matchElem: node.children[i],
-		treeLock:  tree.lock,
+		matchElem: elem,
+		tree:      tree,
 	}
 
 	ok = true
@@ -353,14 +582,74 @@ func (tree *Bptree) Search(key Key) (res *SearchResult, ok bool, err error) {
 	return
 }
 
-func (tree *Bptree) find(key Key, idxAdjust func(*indexNode, int, bool) (int, error)) (paths []*indexNode, err error) {
-	paths = make([]*indexNode, 0, tree.maxDepth)
+// return left-most leaf node, caller must hold tree lock
+func (tree *Bptree) firstLeaf() *indexNode {
+	return tree.head
+}
+
+// cacheEdges finds left-most and right-most leaves again after the tree is
+// built in other way than inserting
+func (tree *Bptree) cacheEdges() {
+	tree.head, tree.tail = nil, nil
 
+	if tree.root == nil {
+		return
+	}
+
+	head := tree.edgePath(ToLeft)
+	tail := tree.edgePath(ToRight)
+
+	tree.head = head[len(head)-1]
+	tree.tail = tail[len(tail)-1]
+}
+
+// edgePath returns paths to the left-most or right-most leaf, caller must
+// hold tree lock
+func (tree *Bptree) edgePath(direction Direction) (paths []*indexNode) {
+	for node := tree.root; node != nil; {
+		paths = append(paths, node)
+
+		if !node.isInternal {
+			break
+		}
+
+		if direction == ToLeft {
+			node = node.children[0].(*indexNode)
+		} else {
+			node = node.children[len(node.children)-1].(*indexNode)
+		}
+	}
+
+	return
+}
+
+// ascend calls fn with alive elements in order until it returns false,
+// caller must hold tree lock
+func (tree *Bptree) ascend(fn func(elem Elem) bool) {
+	now := tree.now()
+
+	for node := tree.firstLeaf(); node != nil; node = node.next {
+		for _, child := range node.children {
+			elem, ok := tree.visible(child, now)
+			if !ok {
+				continue
+			}
+
+			if !fn(elem) {
+				return
+			}
+		}
+	}
+}
+
+func (tree *Bptree) find(key Key, idxAdjust func(*indexNode, int, bool) (int, error)) (paths []*indexNode, err error) {
 	node := tree.root
 	if node == nil {
 		return nil, ERR_EMPTY
 	}
 
+	paths = make([]*indexNode, 0, node.depthToLeaf+1)
+
 	for node != nil {
 		paths = append(paths, node)
 
@@ -393,6 +682,159 @@ func (tree *Bptree) find(key Key, idxAdjust func(*indexNode, int, bool) (int, er
 	return
 }
 
+// findWhere returns paths to the leaf and index in it of the first element
+// having key and satisfying match. Elements of equal keys may be spread over
+// sibling sub-trees, so all candidates are visited. nil match accepts any.
+func (tree *Bptree) findWhere(key Key, match func(Elem) bool) (paths []*indexNode, idx int, err error) {
+	if tree.root == nil {
+		return nil, -1, ERR_EMPTY
+	}
+
+	// a key is in a single place, so descend once
+	if !tree.allowOverlap {
+		paths, err = tree.findToExactElem(key)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		leaf := paths[len(paths)-1]
+
+		idx, equal := leaf.children.find(key)
+		if !equal || (match != nil && !match(leaf.children[idx])) {
+			return nil, -1, ERR_NOT_FOUND
+		}
+
+		return paths, idx, nil
+	}
+
+	var walk func(node *indexNode) bool
+
+	walk = func(node *indexNode) bool {
+		paths = append(paths, node)
+
+		children := node.children
+		i, equal := children.find(key)
+
+		if node.isInternal {
+			// preceding sub-tree may end with the key
+			start := i - 1
+			if start < 0 {
+				start = 0
+			}
+
+			// sub-trees up to last hold the key if any, the rest are compared
+			last := start
+			if equal {
+				last = i
+			}
+
+			for j := start; j < len(children); j++ {
+				if j > last && children[j].Key().CompareTo(key) == Greater {
+					break
+				}
+
+				if walk(children[j].(*indexNode)) {
+					return true
+				}
+			}
+		} else {
+			for ; i < len(children); i++ {
+				if children[i].Key().CompareTo(key) != Equal {
+					break
+				}
+
+				if match == nil || match(children[i]) {
+					idx = i
+					return true
+				}
+			}
+		}
+
+		paths = paths[:len(paths)-1]
+		return false
+	}
+
+	if !walk(tree.root) {
+		return nil, -1, ERR_NOT_FOUND
+	}
+
+	return
+}
+
+// pathTo returns paths from root to leaf, which must hold an element
+func (tree *Bptree) pathTo(leaf *indexNode) (paths []*indexNode, err error) {
+	key := leaf.children[0].Key()
+
+	var walk func(node *indexNode) bool
+
+	walk = func(node *indexNode) bool {
+		paths = append(paths, node)
+
+		if !node.isInternal {
+			if node == leaf {
+				return true
+			}
+
+			paths = paths[:len(paths)-1]
+			return false
+		}
+
+		// leaves of equal keys may precede it
+		start, _ := node.children.find(key)
+		start -= 1
+		if start < 0 {
+			start = 0
+		}
+
+		for j := start; j < len(node.children); j++ {
+			if j > start && node.children[j].Key().CompareTo(key) == Greater {
+				break
+			}
+
+			if walk(node.children[j].(*indexNode)) {
+				return true
+			}
+		}
+
+		paths = paths[:len(paths)-1]
+		return false
+	}
+
+	if tree.root == nil || !walk(tree.root) {
+		return nil, ERR_NOT_FOUND
+	}
+
+	return paths, nil
+}
+
+// findFirst returns paths to the leaf and index in it where the first
+// element not less than key is or would be placed. Index may be the length
+// of the leaf, then the element is in the next one if any.
+func (tree *Bptree) findFirst(key Key) (paths []*indexNode, idx int) {
+	node := tree.root
+	if node == nil {
+		return nil, -1
+	}
+
+	for node.isInternal {
+		i, _ := node.children.find(key)
+
+		// preceding child may have keys equal to or greater than key
+		i -= 1
+		if i < 0 {
+			i = 0
+		}
+
+		paths = append(paths, node)
+		node = node.children[i].(*indexNode)
+	}
+
+	paths = append(paths, node)
+	idx, _ = node.children.find(key)
+
+	return paths, idx
+}
+
 func (tree *Bptree) findToInsert(key Key) (paths []*indexNode, err error) {
 	return tree.find(key, func(node *indexNode, idx int, isEqual bool) (int, error) {
 		if isEqual && !tree.allowOverlap {
@@ -425,6 +867,106 @@ func (tree *Bptree) findToExactElem(key Key) (paths []*indexNode, err error) {
 	})
 }
 
+// maximum number of children a node could have, elements for leaves
+func (tree *Bptree) maxChildren(isInternal bool) int {
+	if isInternal {
+		return tree.maxDegree
+	}
+
+	return tree.leafCapacity
+}
+
+// minimum number of children a node except root must have
+func (tree *Bptree) minChildren(isInternal bool) int {
+	if isInternal {
+		// at least two, otherwise the only child has no sibling to lean on
+		return (tree.maxDegree + 1) / 2
+	}
+
+	return tree.leafCapacity / 2
+}
+
+// seal checks stored element to be placed and hashes it, caller must hold
+// tree lock
+func (tree *Bptree) seal(stored Elem) error {
+	if tree.intervals && !validInterval(unwrapElem(stored)) {
+		return ERR_NOT_INTERVAL
+	}
+
+	if tree.hashing {
+		d, err := hashElem(tree.codec, stored)
+		if err != nil {
+			return err
+		}
+
+		stored.(*entry).digest = d
+	}
+
+	return nil
+}
+
+// refresh sums up bookkeeping, hash and aggregates of node out of its
+// children, which must be up to date already
+func (tree *Bptree) refresh(node *indexNode) {
+	node.count, node.bytes, node.expiring = tree.totalsOf(node)
+
+	if tree.hashing {
+		tree.rehash(node)
+	}
+
+	if tree.monoids != nil {
+		tree.reaggregate(node)
+	}
+}
+
+// visitRange calls whole with sub-trees and each with stored elements having
+// keys in [from, to] in order, nil for unbounded. Sub-trees entirely in the
+// range are not visited down to elements, caller must hold tree lock.
+func (tree *Bptree) visitRange(from, to Key, whole func(*indexNode), each func(Elem)) {
+	if tree.root != nil {
+		tree.visitNode(tree.root, from, to, nil, whole, each)
+	}
+}
+
+// visitNode is visitRange of sub-tree of node, whose keys are not greater
+// than hi
+func (tree *Bptree) visitNode(node *indexNode, from, to, hi Key, whole func(*indexNode), each func(Elem)) {
+	children := node.children
+
+	for i, child := range children {
+		lo := child.Key()
+
+		if to != nil && lo.CompareTo(to) == Greater {
+			return
+		}
+
+		if !node.isInternal {
+			if from == nil || lo.CompareTo(from) != Less {
+				each(child)
+			}
+
+			continue
+		}
+
+		childHi := hi
+		if i+1 < len(children) {
+			childHi = children[i+1].Key()
+		}
+
+		// whole sub-tree is out of or in the range
+		if from != nil && childHi != nil && childHi.CompareTo(from) == Less {
+			continue
+		}
+
+		if (from == nil || lo.CompareTo(from) != Less) && (to == nil || (childHi != nil && childHi.CompareTo(to) != Greater)) {
+			whole(child.(*indexNode))
+			continue
+		}
+
+		tree.visitNode(child.(*indexNode), from, to, childHi, whole, each)
+	}
+}
+
 func (tree *Bptree) balance(paths []*indexNode) error {
 	lenPaths := len(paths)
 
@@ -440,7 +982,7 @@ func (tree *Bptree) balance(paths []*indexNode) error {
 		curr = paths[0]
 
 		parent = &indexNode{
-			children:    make([]Elem, 0, tree.maxDegree+1),
+			children:    make([]Elem, 0, tree.maxChildren(true)+1),
 			depthToLeaf: curr.depthToLeaf + 1,
 			isInternal:  true,
 			next:        nil,
@@ -450,6 +992,8 @@ func (tree *Bptree) balance(paths []*indexNode) error {
 		parent.children = append(parent.children, curr)
 		tree.root = parent
 
+		tree.changed(STRUCTURE_ROOT_GROWN, parent.depthToLeaf+1)
+
 	default:
 		parent = paths[lenPaths-2]
 		curr = paths[lenPaths-1]
@@ -459,7 +1003,7 @@ func (tree *Bptree) balance(paths []*indexNode) error {
 	mid := len(currChildren) / 2
 
 	next = &indexNode{
-		children:    make([]Elem, len(currChildren)-mid, tree.maxDegree+1),
+		children:    make([]Elem, len(currChildren)-mid, tree.maxChildren(curr.isInternal)+1),
 		depthToLeaf: curr.depthToLeaf,
 		isInternal:  curr.isInternal,
 		next:        curr.next,
@@ -474,34 +1018,43 @@ func (tree *Bptree) balance(paths []*indexNode) error {
 		next.next.prev = next
 	}
 
-	err := parent.insertElem(next, tree.maxDegree, tree.allowOverlap)
-	if err != nil {
-		return err
+	if curr == tree.tail {
+		tree.tail = next
 	}
 
+	// next must follow curr even if they start with the same key
+	parent.insertAt(parent.childIndex(curr)+1, next, tree.maxChildren(true))
+
+	tree.refresh(curr)
+	tree.refresh(next)
+	tree.refresh(parent)
+
+	tree.splits += 1
+	tree.changed(STRUCTURE_SPLIT, tree.root.depthToLeaf-curr.depthToLeaf)
+
 	return nil
 }
 
-func (tree *Bptree) redistribution(paths []*indexNode, allowedDegree int) bool {
+func (tree *Bptree) redistribution(paths []*indexNode, allowedDegree int) (bool, error) {
 	lenPaths := len(paths)
 
-	if lenPaths < 1 {
-		panic("redistribution must not be in root")
+	if lenPaths < 2 {
+		return false, tree.corrupted(paths, "redistribution must not be in root")
 	}
 
-	var parent, curr *indexNode
-
-	parent = paths[lenPaths-2]
-	curr = paths[lenPaths-1]
+	curr := paths[lenPaths-1]
 
 	// get siblings
-	lSibling, rSibling := tree.findSiblings(parent, curr.Key())
+	lSibling, rSibling, err := tree.findSiblings(paths)
+	if err != nil {
+		return false, err
+	}
 
 	var withLeft bool
 
 	switch {
 	case lSibling == nil && rSibling == nil:
-		panic("no such case")
+		return false, tree.corrupted(paths, "node has no sibling to redistribute with")
 	case lSibling != nil && rSibling == nil:
 		withLeft = true
 	case lSibling == nil && rSibling != nil:
@@ -518,40 +1071,48 @@ func (tree *Bptree) redistribution(paths []*indexNode, allowedDegree int) bool {
 		// redistribution with left sibling
 		lsChildrenLen := len(lSibling.children)
 
-		if lsChildrenLen-1 <= allowedDegree {
-			return false
+		if lsChildrenLen-1 < allowedDegree {
+			return false, nil
 		}
 
 		borrow := lSibling.children[lsChildrenLen-1]
 		lSibling.children = lSibling.children[:lsChildrenLen-1]
 
-		newChildren := make([]Elem, len(curr.children)+1, tree.maxDegree+1)
+		newChildren := make([]Elem, len(curr.children)+1, tree.maxChildren(curr.isInternal)+1)
 		newChildren[0] = borrow
 		copy(newChildren[1:], curr.children)
 
 		curr.children = newChildren
+		tree.refresh(lSibling)
 	} else {
 		// redistribution with right sibling
 		rsChildrenLen := len(rSibling.children)
 
-		if rsChildrenLen-1 <= allowedDegree {
-			return false
+		if rsChildrenLen-1 < allowedDegree {
+			return false, nil
 		}
 
 		borrow := rSibling.children[0]
 		rSibling.children = rSibling.children[1:]
 
 		curr.children = append(curr.children, borrow)
+		tree.refresh(rSibling)
 	}
 
-	return true
+	curr._tmpKey = nil
+	tree.refresh(curr)
+
+	tree.redistributions += 1
+	tree.changed(STRUCTURE_REDISTRIBUTION, lenPaths-1)
+
+	return true, nil
 }
 
 func (tree *Bptree) merge(paths []*indexNode) error {
 	lenPaths := len(paths)
 
-	if lenPaths < 1 {
-		panic("merge must not be in root")
+	if lenPaths < 2 {
+		return tree.corrupted(paths, "merge must not be in root")
 	}
 
 	var parent, curr *indexNode
@@ -560,21 +1121,19 @@ func (tree *Bptree) merge(paths []*indexNode) error {
 	curr = paths[lenPaths-1]
 
 	// calculate max children
-	var allowedDegree int
-	if curr.isInternal {
-		allowedDegree = tree.maxDegree
-	} else {
-		allowedDegree = tree.maxDegree - 1
-	}
+	allowedDegree := tree.maxChildren(curr.isInternal)
 
 	// get siblings
-	lSibling, rSibling := tree.findSiblings(parent, curr.Key())
+	lSibling, rSibling, err := tree.findSiblings(paths)
+	if err != nil {
+		return err
+	}
 
 	var withLeft bool
 
 	switch {
 	case lSibling == nil && rSibling == nil:
-		panic("no such case")
+		return tree.corrupted(paths, "node has no sibling to merge with")
 	case lSibling != nil && rSibling == nil:
 		withLeft = true
 	case lSibling == nil && rSibling != nil:
@@ -590,7 +1149,7 @@ func (tree *Bptree) merge(paths []*indexNode) error {
 	if withLeft {
 		// merging with left sibling
 		if len(lSibling.children)+len(curr.children) > allowedDegree {
-			panic("number of children must be after merging")
+			return tree.corrupted(paths, "merging with left sibling overflows to %d children", len(lSibling.children)+len(curr.children))
 		}
 
 		lSibling.children = append(lSibling.children, curr.children...)
@@ -600,11 +1159,17 @@ func (tree *Bptree) merge(paths []*indexNode) error {
 			curr.next.prev = lSibling
 		}
 
-		parent.deleteElem(curr.Key(), tree.maxDegree)
+		if curr == tree.tail {
+			tree.tail = lSibling
+		}
+
+		tree.refresh(lSibling)
+
+		parent.deleteAt(parent.childIndex(curr), tree.maxChildren(true))
 	} else {
 		// merging with right sibling
 		if len(rSibling.children)+len(curr.children) > allowedDegree {
-			panic("number of children must be after merging")
+			return tree.corrupted(paths, "merging with right sibling overflows to %d children", len(rSibling.children)+len(curr.children))
 		}
 
 		rSibling.children = append(curr.children, rSibling.children...)
@@ -614,18 +1179,33 @@ func (tree *Bptree) merge(paths []*indexNode) error {
 			curr.prev.next = rSibling
 		}
 
-		parent.deleteElem(curr.Key(), tree.maxDegree)
+		if curr == tree.head {
+			tree.head = rSibling
+		}
+
+		tree.refresh(rSibling)
+
+		parent.deleteAt(parent.childIndex(curr), tree.maxChildren(true))
 	}
 
+	tree.merges += 1
+	tree.changed(STRUCTURE_MERGE, lenPaths-1)
+
 	return nil
 }
 
-func (tree *Bptree) findSiblings(parent *indexNode, key Key) (left, right *indexNode) {
+// findSiblings returns siblings of the node at the end of paths under the
+// same parent
+func (tree *Bptree) findSiblings(paths []*indexNode) (left, right *indexNode, err error) {
+	parent := paths[len(paths)-2]
+	curr := paths[len(paths)-1]
+
 	pChildrenLen := len(parent.children)
 
-	i, equal := parent.children.find(key)
-	if !equal {
-		panic("parent must have the duty of supporting")
+	i := parent.childIndex(curr)
+	if i < 0 {
+		err = tree.corrupted(paths[:len(paths)-1], "node is not a child of its parent")
+		return
 	}
 
 	if i != 0 {
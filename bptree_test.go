@@ -1,6 +1,7 @@
 package bptree
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -446,3 +447,266 @@ func TestElemRangeToIfThereIsOneElementInTree(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestRemoveAll(t *testing.T) {
+	for _, maxDegree := range []int{3, 4, 5, 8, 32} {
+		tree, err := NewBptree(maxDegree, _maxDepth, false)
+		if err != nil {
+			t.Errorf("while creating bptree: %v", err)
+			t.FailNow()
+		}
+
+		for _, v := range rand.Perm(2000) {
+			err = tree.Insert(&testElem{v})
+			if err != nil {
+				t.Errorf("while inserting %d (degree %d): %v", v, maxDegree, err)
+				t.FailNow()
+			}
+		}
+
+		for _, v := range rand.Perm(2000) {
+			err = tree.Remove(testKey(v))
+			if err != nil {
+				t.Errorf("while removing %d (degree %d): %v", v, maxDegree, err)
+				t.FailNow()
+			}
+		}
+
+		err = tree.Remove(testKey(0))
+		if !errors.Is(err, ERR_NOT_FOUND) {
+			t.Errorf("removing from emptied tree must be not found, but %v", err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestKeyError(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	var keyErr *KeyError
+
+	err = tree.Insert(&testElem{3})
+	if !errors.Is(err, ERR_OVERLAPPED) || !errors.As(err, &keyErr) || keyErr.Op != "insert" || keyErr.Key != testKey(3) {
+		t.Errorf("overlapped insertion must tell the key, but %v", err)
+	}
+
+	err = tree.Remove(testKey(10))
+	if !errors.Is(err, ERR_NOT_FOUND) || !errors.As(err, &keyErr) || keyErr.Op != "remove" || keyErr.Key != testKey(10) {
+		t.Errorf("removing absent key must tell the key, but %v", err)
+	}
+
+	_, _, err = tree.SearchNearby(testKey(10), ToRight)
+	if !errors.Is(err, ERR_SEARCH_OVERFLOWED) || !errors.As(err, &keyErr) || keyErr.Key != testKey(10) {
+		t.Errorf("overflowed search must tell the key, but %v", err)
+	}
+
+	// errors of hooks are not wrapped
+	hookErr := errors.New("rejected")
+
+	tree.BeforeInsert(func(elem Elem) error {
+		return hookErr
+	})
+
+	err = tree.Insert(&testElem{20})
+	if err != hookErr {
+		t.Errorf("hook error must be returned as it is, but %v", err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	sum := 0
+	vetoErr := fmt.Errorf("vetoed")
+
+	tree.BeforeInsert(func(elem Elem) error {
+		if elem.(*testElem).val < 0 {
+			return vetoErr
+		}
+		return nil
+	})
+	tree.AfterInsert(func(elem Elem) error {
+		if elem.(*testElem).val == 13 {
+			return vetoErr
+		}
+
+		sum += elem.(*testElem).val
+		return nil
+	})
+	tree.BeforeRemove(func(elem Elem) error {
+		if elem.(*testElem).val == 7 {
+			return vetoErr
+		}
+		return nil
+	})
+	tree.AfterRemove(func(elem Elem) error {
+		if elem.(*testElem).val == 8 {
+			return vetoErr
+		}
+
+		sum -= elem.(*testElem).val
+		return nil
+	})
+
+	for i := -5; i < 20; i++ {
+		err = tree.Insert(&testElem{i})
+
+		if (i < 0 || i == 13) != (err == vetoErr) {
+			t.Errorf("unexpected result inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		err = tree.Remove(testKey(i))
+
+		if (i == 7 || i == 8) != (err == vetoErr) {
+			t.Errorf("unexpected result removing %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	// 7, 8 and 10..19 except 13
+	if sum != 7+8+(10+19)*10/2-13 {
+		t.Errorf("sum maintained by hooks is wrong: %d", sum)
+	}
+
+	for _, v := range []int{-1, 0, 13} {
+		_, ok, _ := tree.SearchElem(testKey(v))
+		if ok {
+			t.Errorf("vetoed element %d must not be found", v)
+		}
+	}
+
+	for _, v := range []int{7, 8, 12} {
+		_, ok, _ := tree.SearchElem(testKey(v))
+		if !ok {
+			t.Errorf("element %d must be found", v)
+		}
+	}
+}
+
+func TestVerifyOnMutation(t *testing.T) {
+	for _, maxDegree := range []int{3, 4, 5, 7, 16} {
+		for _, allowOverlap := range []bool{false, true} {
+			tree, err := NewBptree(maxDegree, _maxDepth, allowOverlap)
+			if err != nil {
+				t.Errorf("while creating bptree: %v", err)
+				t.FailNow()
+			}
+
+			tree.SetVerifyOnMutation(true)
+
+			for i := 0; i < 1000; i++ {
+				v := rand.Intn(200)
+
+				if rand.Intn(3) == 0 {
+					err = tree.Remove(testKey(v))
+				} else {
+					err = tree.Insert(&testElem{v})
+				}
+
+				if errors.Is(err, ERR_CORRUPTED) {
+					t.Errorf("degree %d, overlap %v: %v\n%s", maxDegree, allowOverlap, err, err.(*ErrCorrupted).Dump)
+					t.FailNow()
+				}
+			}
+
+			err = tree.Verify()
+			if err != nil {
+				t.Errorf("degree %d, overlap %v: %v", maxDegree, allowOverlap, err)
+			}
+		}
+	}
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("tree must be valid: %v", err)
+		t.FailNow()
+	}
+
+	// swap two elements of a leaf
+	leaf := tree.firstLeaf()
+	leaf.children[0], leaf.children[1] = leaf.children[1], leaf.children[0]
+
+	err = tree.Verify()
+	if !errors.Is(err, ERR_INVARIANT_VIOLATED) {
+		t.Errorf("out of order keys must be detected, but %v", err)
+	}
+
+	leaf.children[0], leaf.children[1] = leaf.children[1], leaf.children[0]
+
+	// break a link
+	leaf.next.prev = nil
+
+	err = tree.Verify()
+	if !errors.Is(err, ERR_INVARIANT_VIOLATED) {
+		t.Errorf("broken link must be detected, but %v", err)
+	}
+}
+
+// failingCodec fails encoding elements once fail is set
+type failingCodec struct {
+	testCodec
+	fail *bool
+}
+
+func (c failingCodec) EncodeElem(elem Elem) ([]byte, error) {
+	if *c.fail {
+		return nil, fmt.Errorf("encoding failed")
+	}
+
+	return c.testCodec.EncodeElem(elem)
+}
+
+func TestHookRollbackFailurePoisons(t *testing.T) {
+	fail := false
+
+	tree, err := New(WithDegree(4), WithCodec(failingCodec{fail: &fail}), WithMerkle())
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	vetoErr := fmt.Errorf("vetoed")
+
+	// the removed element can't be sealed again to be put back
+	tree.AfterRemove(func(elem Elem) error {
+		fail = true
+		return vetoErr
+	})
+
+	err = tree.Remove(testKey(5))
+	if !errors.Is(err, ERR_CORRUPTED) || tree.Poisoned() == nil {
+		t.Errorf("lost element must poison the tree, but %v", err)
+		t.FailNow()
+	}
+}
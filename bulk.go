@@ -0,0 +1,153 @@
+package bptree
+
+import (
+	"errors"
+	"math"
+)
+
+// BulkLoad fills an empty tree with elems sorted by key, building nodes from
+// leaves up to root instead of inserting one by one. Nodes are filled by the
+// fill factor given to New. No insert hooks run, but elements evicted as
+// the tree exceeds its capacity run After remove hooks like any eviction.
+func (tree *Bptree) BulkLoad(elems Elems) (err error) {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated("bulk load", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	if tree.count > 0 {
+		return errors.New("tree must be empty for bulk loading")
+	}
+
+	for i := 1; i < len(elems); i++ {
+		cond := elems[i-1].Key().CompareTo(elems[i].Key())
+
+		if cond == Greater {
+			return errors.New("elements must be sorted for bulk loading")
+		}
+
+		if cond == Equal && !tree.allowOverlap {
+			return keyError("bulk load", elems[i].Key(), ERR_OVERLAPPED)
+		}
+	}
+
+	return tree.load(elems)
+}
+
+// load builds the empty tree out of sorted elems and accounts them, caller
+// must hold tree lock
+func (tree *Bptree) load(elems Elems) error {
+	stored := make(Elems, len(elems))
+	for i, elem := range elems {
+		stored[i] = tree.wrap(elem)
+
+		err := tree.seal(stored[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	root := tree.build(stored)
+
+	if tree.maxDepth >= 0 && root != nil && root.depthToLeaf > tree.maxDepth {
+		return ERR_EXCEED_MAX_DEPTH
+	}
+
+	tree.root = root
+	tree.cacheEdges()
+
+	for _, elem := range stored {
+		tree.account(elem, 1)
+	}
+
+	tree.evict()
+
+	return nil
+}
+
+// build makes a tree of sorted stored elements and returns its root, nil if
+// no element
+func (tree *Bptree) build(stored Elems) *indexNode {
+	if len(stored) == 0 {
+		return nil
+	}
+
+	nodes := tree.pack(stored, false, 0)
+
+	for depthToLeaf := 1; len(nodes) > 1; depthToLeaf++ {
+		children := make(Elems, len(nodes))
+		for i, node := range nodes {
+			children[i] = node
+		}
+
+		nodes = tree.pack(children, true, depthToLeaf)
+	}
+
+	return nodes[0]
+}
+
+// pack distributes children evenly over linked nodes filled by fill factor,
+// while each node keeps its minimum and maximum number of children
+func (tree *Bptree) pack(children Elems, isInternal bool, depthToLeaf int) []*indexNode {
+	n := len(children)
+	most := tree.maxChildren(isInternal)
+	least := tree.minChildren(isInternal)
+
+	target := int(math.Ceil(tree.fillFactor * float64(most)))
+	if target < least {
+		target = least
+	}
+
+	count := (n + target - 1) / target
+
+	if count > 1 && n/count < least {
+		count = n / least
+	}
+
+	if fewest := (n + most - 1) / most; count < fewest {
+		count = fewest
+	}
+
+	nodes := make([]*indexNode, count)
+
+	var prev *indexNode
+	start := 0
+
+	for i := range nodes {
+		// the first n%count nodes take one more
+		size := n / count
+		if i < n%count {
+			size += 1
+		}
+
+		node := &indexNode{
+			children:    make(Elems, size, most+1),
+			depthToLeaf: depthToLeaf,
+			isInternal:  isInternal,
+			prev:        prev,
+		}
+
+		copy(node.children, children[start:start+size])
+		start += size
+
+		tree.refresh(node)
+
+		if prev != nil {
+			prev.next = node
+		}
+
+		nodes[i] = node
+		prev = node
+	}
+
+	return nodes
+}
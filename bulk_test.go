@@ -0,0 +1,130 @@
+package bptree
+
+import (
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, maxDegree := range []int{3, 4, 5, 16} {
+		for _, fillFactor := range []float64{0.1, 0.5, 0.75, 1} {
+			for _, n := range []int{0, 1, 2, 3, 10, 100, 1000} {
+				tree, err := New(WithDegree(maxDegree), WithFillFactor(fillFactor))
+				if err != nil {
+					t.Errorf("while creating bptree: %v", err)
+					t.FailNow()
+				}
+
+				elems := make(Elems, n)
+				for i := range elems {
+					elems[i] = &testElem{i}
+				}
+
+				err = tree.BulkLoad(elems)
+				if err != nil {
+					t.Errorf("while loading %d elements (degree %d, fill %v): %v", n, maxDegree, fillFactor, err)
+					t.FailNow()
+				}
+
+				err = tree.Verify()
+				if err != nil {
+					t.Errorf("loaded %d elements (degree %d, fill %v): %v", n, maxDegree, fillFactor, err)
+					t.FailNow()
+				}
+
+				vals := make([]int, n)
+				for i := range vals {
+					vals[i] = i
+				}
+
+				checkElems(t, tree, vals...)
+
+				// loaded tree keeps working
+				tree.Insert(&testElem{n})
+				tree.Remove(testKey(0))
+
+				err = tree.Verify()
+				if err != nil {
+					t.Errorf("after loading %d elements (degree %d, fill %v): %v", n, maxDegree, fillFactor, err)
+					t.FailNow()
+				}
+			}
+		}
+	}
+}
+
+func TestBulkLoadFill(t *testing.T) {
+	elems := make(Elems, 1000)
+	for i := range elems {
+		elems[i] = &testElem{i}
+	}
+
+	full, _ := New(WithDegree(11))
+	full.BulkLoad(elems)
+
+	half, _ := New(WithDegree(11), WithFillFactor(0.5))
+	half.BulkLoad(elems)
+
+	// 10 elements in a full leaf, 5 in a half one
+	if full.Stats().LeafNodes != 100 || half.Stats().LeafNodes != 200 {
+		t.Errorf("unexpected leaves: %d, %d", full.Stats().LeafNodes, half.Stats().LeafNodes)
+	}
+}
+
+func TestBulkLoadRejects(t *testing.T) {
+	tree, _ := New(WithDegree(4))
+
+	err := tree.BulkLoad(Elems{&testElem{2}, &testElem{1}})
+	if err == nil {
+		t.Errorf("unsorted elements must be rejected")
+	}
+
+	err = tree.BulkLoad(Elems{&testElem{1}, &testElem{1}})
+	if err == nil {
+		t.Errorf("duplicated keys must be rejected")
+	}
+
+	tree.Insert(&testElem{1})
+
+	err = tree.BulkLoad(Elems{&testElem{2}})
+	if err == nil {
+		t.Errorf("loading into non-empty tree must be rejected")
+	}
+}
+
+func TestBulkLoadEvictionRunsRemoveHooks(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	tree.SetCapacity(Capacity{MaxElems: 60, Policy: EVICT_LOWEST_KEY})
+
+	var inserted, removed int
+
+	tree.AfterInsert(func(elem Elem) error {
+		inserted += 1
+		return nil
+	})
+
+	tree.AfterRemove(func(elem Elem) error {
+		removed += 1
+		return nil
+	})
+
+	var elems Elems
+	for i := 0; i < 100; i++ {
+		elems = append(elems, &testElem{i})
+	}
+
+	err = tree.BulkLoad(elems)
+	if err != nil {
+		t.Errorf("while bulk loading: %v", err)
+		t.FailNow()
+	}
+
+	if inserted != 0 || removed != 40 || tree.Len() != 60 {
+		t.Errorf("40 evicted elements must run remove hooks only, but %d inserted, %d removed of %d", inserted, removed, tree.Len())
+		t.FailNow()
+	}
+}
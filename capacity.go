@@ -0,0 +1,228 @@
+package bptree
+
+import (
+	"container/list"
+	"errors"
+)
+
+type EvictionPolicy int
+
+const (
+	EVICT_LOWEST_KEY EvictionPolicy = iota
+	EVICT_HIGHEST_KEY
+	EVICT_LEAST_RECENTLY_USED
+)
+
+// Capacity bounds a tree by number of elements and/or approximate size in
+// bytes. Once an insertion exceeds a bound, elements chosen by Policy are
+// evicted until the tree fits again.
+type Capacity struct {
+	MaxElems int   // zero for no bound
+	MaxBytes int64 // zero for no bound, SizeOf must be specified
+
+	SizeOf func(elem Elem) int
+
+	Policy EvictionPolicy
+}
+
+// EvictHook is called with elements evicted to keep capacity. Like Hook, it
+// runs while the tree is write locked.
+type EvictHook func(elems Elems)
+
+// SetCapacity bounds the tree, evicting elements right away if it doesn't
+// fit. Zero Capacity removes the bounds.
+func (tree *Bptree) SetCapacity(capacity Capacity) (err error) {
+	if capacity.MaxElems < 0 || capacity.MaxBytes < 0 {
+		return errors.New("capacity must to have zero or a positive value")
+	}
+
+	if capacity.MaxBytes > 0 && capacity.SizeOf == nil {
+		return errors.New("size function must be specified for bounding bytes")
+	}
+
+	switch capacity.Policy {
+	case EVICT_LOWEST_KEY, EVICT_HIGHEST_KEY, EVICT_LEAST_RECENTLY_USED:
+	default:
+		return errors.New("unknown eviction policy")
+	}
+
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated("set capacity", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	tree.capacity = capacity
+
+	// recount with new size function, from leaves up to root
+	levels := tree.levels()
+
+	for depth := len(levels) - 1; depth >= 0; depth-- {
+		for _, node := range levels[depth] {
+			node.count, node.bytes, node.expiring = tree.totalsOf(node)
+		}
+	}
+
+	tree.bytes = 0
+	if tree.root != nil {
+		tree.bytes = tree.root.bytes
+	}
+
+	tracking := capacity.Policy == EVICT_LEAST_RECENTLY_USED
+
+	switch {
+	case tracking && tree.recency == nil:
+		// start tracking existing elements, they are regarded as accessed
+		// in order of keys
+		tree.recency = list.New()
+
+		for node := tree.firstLeaf(); node != nil; node = node.next {
+			for i, child := range node.children {
+				e := tree.wrap(child).(*entry)
+				e.access = tree.recency.PushFront(e)
+
+				node.children[i] = e
+			}
+		}
+
+	case !tracking && tree.recency != nil:
+		for node := tree.firstLeaf(); node != nil; node = node.next {
+			for _, child := range node.children {
+				if e, ok := child.(*entry); ok {
+					e.access = nil
+				}
+			}
+		}
+
+		tree.recency = nil
+	}
+
+	tree.evict()
+
+	return nil
+}
+
+// Len returns number of elements stored in the tree, including expired ones
+// not removed yet, and zero for uninitialized tree.
+func (tree *Bptree) Len() int {
+	if !tree.initialized {
+		return 0
+	}
+
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.count
+}
+
+// Evicted returns number of elements evicted since the tree was created,
+// zero for uninitialized tree.
+func (tree *Bptree) Evicted() uint64 {
+	if !tree.initialized {
+		return 0
+	}
+
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.evicted
+}
+
+func (tree *Bptree) OnEvict(hook EvictHook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.evictHooks = append(tree.evictHooks, hook)
+}
+
+// touch marks stored element as accessed, caller must hold tree lock for
+// reading at least
+func (tree *Bptree) touch(stored Elem) {
+	if tree.recency == nil {
+		return
+	}
+
+	e, ok := stored.(*entry)
+	if !ok || e.access == nil {
+		return
+	}
+
+	tree.recencyLock.Lock()
+	tree.recency.MoveToFront(e.access)
+	tree.recencyLock.Unlock()
+}
+
+func (tree *Bptree) overCapacity() bool {
+	capacity := tree.capacity
+
+	if capacity.MaxElems > 0 && tree.count > capacity.MaxElems {
+		return true
+	}
+
+	if capacity.MaxBytes > 0 && tree.bytes > capacity.MaxBytes {
+		return true
+	}
+
+	return false
+}
+
+// evict removes elements until the tree fits in capacity, caller must hold
+// tree lock
+func (tree *Bptree) evict() {
+	var elems Elems
+
+	for tree.count > 0 && tree.overCapacity() {
+		var paths []*indexNode
+		var idx int
+
+		switch tree.capacity.Policy {
+		case EVICT_LOWEST_KEY:
+			paths = tree.edgePath(ToLeft)
+			idx = 0
+
+		case EVICT_HIGHEST_KEY:
+			paths = tree.edgePath(ToRight)
+			idx = len(paths[len(paths)-1].children) - 1
+
+		case EVICT_LEAST_RECENTLY_USED:
+			e := tree.recency.Back().Value.(*entry)
+
+			var err error
+
+			paths, idx, err = tree.findWhere(e.Key(), func(elem Elem) bool {
+				return elem == Elem(e)
+			})
+			if err != nil {
+				// recency list must cover all elements
+				return
+			}
+		}
+
+		elems = append(elems, unwrapElem(paths[len(paths)-1].children[idx]))
+
+		err := tree.removeAt(paths, idx)
+		if err != nil {
+			break
+		}
+	}
+
+	if len(elems) == 0 {
+		return
+	}
+
+	tree.evicted += uint64(len(elems))
+
+	tree.hooks.notify(tree.hooks.afterRemove, elems)
+
+	for _, hook := range tree.evictHooks {
+		hook(elems)
+	}
+}
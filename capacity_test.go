@@ -0,0 +1,160 @@
+package bptree
+
+import (
+	"testing"
+)
+
+func newTestBoundedTree(t *testing.T, capacity Capacity) *Bptree {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.SetCapacity(capacity)
+	if err != nil {
+		t.Errorf("while setting capacity: %v", err)
+		t.FailNow()
+	}
+
+	return tree
+}
+
+func checkElems(t *testing.T, tree *Bptree, vals ...int) {
+	if tree.Len() != len(vals) {
+		t.Errorf("tree must have %d elements, but %d", len(vals), tree.Len())
+		t.FailNow()
+	}
+
+	for _, v := range vals {
+		_, ok, _ := tree.SearchElem(testKey(v))
+		if !ok {
+			t.Errorf("element %d must be found", v)
+			t.FailNow()
+		}
+	}
+}
+
+func TestCapacityEvictLowestKey(t *testing.T) {
+	tree := newTestBoundedTree(t, Capacity{MaxElems: 10, Policy: EVICT_LOWEST_KEY})
+
+	var evicted Elems
+
+	tree.OnEvict(func(elems Elems) {
+		evicted = append(evicted, elems...)
+	})
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	checkElems(t, tree, 90, 91, 92, 93, 94, 95, 96, 97, 98, 99)
+
+	if tree.Evicted() != 90 || len(evicted) != 90 {
+		t.Errorf("90 elements must be evicted, but %d, %d", tree.Evicted(), len(evicted))
+	}
+
+	if evicted[0].(*testElem).val != 0 || evicted[89].(*testElem).val != 89 {
+		t.Errorf("evicted elements are not the lowest: %v", evicted)
+	}
+}
+
+func TestCapacityEvictHighestKey(t *testing.T) {
+	tree := newTestBoundedTree(t, Capacity{MaxElems: 5, Policy: EVICT_HIGHEST_KEY})
+
+	for i := 99; i >= 0; i-- {
+		tree.Insert(&testElem{i})
+	}
+
+	checkElems(t, tree, 0, 1, 2, 3, 4)
+}
+
+func TestCapacityEvictLeastRecentlyUsed(t *testing.T) {
+	tree := newTestBoundedTree(t, Capacity{MaxElems: 3, Policy: EVICT_LEAST_RECENTLY_USED})
+
+	for i := 0; i < 3; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	// 1 becomes the least recently used
+	tree.SearchElem(testKey(0))
+	tree.SearchElemNearby(testKey(2), ToLeft)
+
+	tree.Insert(&testElem{3})
+	checkElems(t, tree, 0, 2, 3)
+
+	tree.Insert(&testElem{4})
+	checkElems(t, tree, 2, 3, 4)
+}
+
+func TestCapacityBytes(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	// bounding existing tree evicts right away
+	err = tree.SetCapacity(Capacity{
+		MaxBytes: 20,
+		SizeOf: func(elem Elem) int {
+			return elem.(*testElem).val
+		},
+		Policy: EVICT_HIGHEST_KEY,
+	})
+	if err != nil {
+		t.Errorf("while setting capacity: %v", err)
+		t.FailNow()
+	}
+
+	// 0+1+2+3+4+5 = 15 <= 20 < 21
+	checkElems(t, tree, 0, 1, 2, 3, 4, 5)
+
+	err = tree.SetCapacity(Capacity{MaxBytes: 20})
+	if err == nil {
+		t.Errorf("bounding bytes without size function must fail")
+	}
+}
+
+func TestCapacityRunsRemoveHooks(t *testing.T) {
+	tree := newTestBoundedTree(t, Capacity{MaxElems: 20, Policy: EVICT_LOWEST_KEY})
+
+	// derived count kept by hooks
+	count := 0
+
+	tree.AfterInsert(func(elem Elem) error {
+		count += 1
+		return nil
+	})
+
+	tree.AfterRemove(func(elem Elem) error {
+		count -= 1
+		return nil
+	})
+
+	for i := 0; i < 30; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	tree.SetCapacity(Capacity{MaxElems: 3, Policy: EVICT_LOWEST_KEY})
+
+	checkElems(t, tree, 27, 28, 29)
+
+	if count != tree.Len() {
+		t.Errorf("count kept by hooks is %d, but tree has %d", count, tree.Len())
+		t.FailNow()
+	}
+}
+
+func TestCapacityNotInitialized(t *testing.T) {
+	var tree Bptree
+
+	if tree.Len() != 0 || tree.Evicted() != 0 {
+		t.Errorf("uninitialized tree must have nothing")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,226 @@
+package bptree
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Clear removes every element at once, keeping configuration, hooks and
+// capacity of the tree. After remove hooks run for every removed element,
+// including expired ones not removed yet, once the tree is emptied.
+func (tree *Bptree) Clear() (err error) {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.observer != nil {
+		defer tree.observe(OP_CLEAR, nil, time.Now(), &err)
+	}
+
+	defer tree.mutated("clear", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	var removed Elems
+
+	if len(tree.hooks.afterRemove) > 0 {
+		removed = make(Elems, 0, tree.count)
+
+		for node := tree.head; node != nil; node = node.next {
+			for _, stored := range node.children {
+				removed = append(removed, unwrapElem(stored))
+			}
+		}
+	}
+
+	tree.reset()
+
+	tree.hooks.notify(tree.hooks.afterRemove, removed)
+
+	return nil
+}
+
+// reset drops all elements without looking at them, caller must hold tree
+// lock
+func (tree *Bptree) reset() {
+	tree.root, tree.head, tree.tail = nil, nil, nil
+
+	tree.count = 0
+	tree.bytes = 0
+	tree.expiring = 0
+	tree.nextDeadline = 0
+
+	if tree.recency != nil {
+		tree.recency = list.New()
+	}
+
+	if tree.journal != nil {
+		tree.journal(msgClear, nil)
+	}
+}
+
+// Clone returns an independent tree of the same configuration, capacity and
+// structure, holding the same elements. Elements themselves are shared, but
+// hooks, observer and reaper are not carried over.
+func (tree *Bptree) Clone() (clone *Bptree, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	defer tree.recovered("clone", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	clone = tree.emptyCopy()
+
+	clone.count = tree.count
+	clone.bytes = tree.bytes
+	clone.expiring = tree.expiring
+	clone.nextDeadline = tree.nextDeadline
+
+	entries := make(map[*entry]*entry)
+
+	if tree.root != nil {
+		// last copied node of each depth to leaf, to link next one with
+		last := make([]*indexNode, tree.root.depthToLeaf+1)
+
+		clone.root = cloneNode(tree.root, last, entries)
+		clone.cacheEdges()
+	}
+
+	if tree.recency != nil {
+		// readers move accesses under recency lock while holding read lock
+		tree.recencyLock.Lock()
+		defer tree.recencyLock.Unlock()
+
+		// keep the order of accesses
+		for access := tree.recency.Back(); access != nil; access = access.Prev() {
+			e := entries[access.Value.(*entry)]
+			e.access = clone.recency.PushFront(e)
+		}
+	}
+
+	return clone, nil
+}
+
+// emptyCopy returns an empty tree of the same configuration and capacity,
+// caller must hold tree lock
+func (tree *Bptree) emptyCopy() *Bptree {
+	copied := &Bptree{
+		maxDegree:        tree.maxDegree,
+		leafCapacity:     tree.leafCapacity,
+		maxDepth:         tree.maxDepth,
+		allowOverlap:     tree.allowOverlap,
+		fillFactor:       tree.fillFactor,
+		codec:            tree.codec,
+		hashing:          tree.hashing,
+		monoid:           tree.monoid,
+		monoids:          tree.monoids,
+		intervals:        tree.intervals,
+		clock:            tree.clock,
+		capacity:         tree.capacity,
+		verifyOnMutation: tree.verifyOnMutation,
+		recoverPanics:    tree.recoverPanics,
+		recencyLock:      new(sync.Mutex),
+		lock:             new(sync.RWMutex),
+		initialized:      true,
+	}
+
+	if tree.recency != nil {
+		copied.recency = list.New()
+	}
+
+	return copied
+}
+
+// cloneNode copies sub-tree of node, linking copies to the last ones of each
+// depth to leaf. Entries are copied as well and recorded into entries.
+func cloneNode(node *indexNode, last []*indexNode, entries map[*entry]*entry) *indexNode {
+	copied := &indexNode{
+		children:    make(Elems, len(node.children), cap(node.children)),
+		isInternal:  node.isInternal,
+		depthToLeaf: node.depthToLeaf,
+		prev:        last[node.depthToLeaf],
+		hash:        node.hash,
+		aggs:        node.aggs,
+		count:       node.count,
+		bytes:       node.bytes,
+		expiring:    node.expiring,
+	}
+
+	if copied.prev != nil {
+		copied.prev.next = copied
+	}
+
+	last[node.depthToLeaf] = copied
+
+	for i, child := range node.children {
+		switch c := child.(type) {
+		case *indexNode:
+			copied.children[i] = cloneNode(c, last, entries)
+
+		case *entry:
+			e := &entry{Elem: c.Elem, deadline: c.deadline, digest: c.digest}
+			entries[c] = e
+			copied.children[i] = e
+
+		default:
+			copied.children[i] = child
+		}
+	}
+
+	return copied
+}
+
+// Equal reports whether both trees have alive elements of equal keys in the
+// same order, and elemEq holds for each pair of them. nil elemEq compares
+// keys only. Elements of other are read before the tree, so the trees are
+// not compared at a single point in time while they change.
+func (tree *Bptree) Equal(other *Bptree, elemEq func(a, b Elem) bool) bool {
+	if !tree.initialized || !other.initialized {
+		return false
+	}
+
+	elems, err := other.alive("equal")
+	if err != nil {
+		return false
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	equal := true
+	i := 0
+
+	tree.ascend(func(elem Elem) bool {
+		if i >= len(elems) || elem.Key().CompareTo(elems[i].Key()) != Equal {
+			equal = false
+			return false
+		}
+
+		if elemEq != nil && !elemEq(elem, elems[i]) {
+			equal = false
+			return false
+		}
+
+		i += 1
+		return true
+	})
+
+	return equal && i == len(elems)
+}
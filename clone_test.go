@@ -0,0 +1,283 @@
+package bptree
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClear(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	err = tree.Clear()
+	if err != nil {
+		t.Errorf("while clearing: %v", err)
+		t.FailNow()
+	}
+
+	if tree.Len() != 0 {
+		t.Errorf("cleared tree has %d elements", tree.Len())
+		t.FailNow()
+	}
+
+	_, ok, _ := tree.SearchElem(testKey(50))
+	if ok {
+		t.Errorf("element must not be found after clear")
+		t.FailNow()
+	}
+
+	// cleared tree is usable again
+	for i := 0; i < 10; i++ {
+		err = tree.Insert(&testElem{i})
+		if err != nil {
+			t.Errorf("while inserting %d after clear: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestRecognizableBptreeClear(t *testing.T) {
+	rbptree, err := NewRecognizableBptree(_maxDegree, _maxDepth, _allowOverlap)
+	if err != nil {
+		t.Errorf("while creating recognizable bptree: %v", err)
+		t.FailNow()
+	}
+
+	rbptree.Bptree.Insert(&testElem{0})
+
+	notify := rbptree.AddWatch()
+
+	rbptree.Clear()
+
+	select {
+	case event := <-notify:
+		if event != EVENT_MODIFIED {
+			t.Errorf("clear must notify modification, but %d", event)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timeouted")
+		t.FailNow()
+	}
+}
+
+func TestClone(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	tree.SetCapacity(Capacity{MaxElems: 100, Policy: EVICT_LEAST_RECENTLY_USED})
+
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			tree.InsertWithTTL(&testElem{i}, time.Minute)
+		} else {
+			tree.Insert(&testElem{i})
+		}
+	}
+
+	// 0 becomes the most recently used
+	tree.Search(testKey(0))
+
+	clone, err := tree.Clone()
+	if err != nil {
+		t.Errorf("while cloning: %v", err)
+		t.FailNow()
+	}
+
+	var original, cloned bytes.Buffer
+
+	tree.WriteJSON(&original, ExportOptions{})
+	clone.WriteJSON(&cloned, ExportOptions{})
+
+	if original.String() != cloned.String() {
+		t.Errorf("clone has different structure:\n%s\nexpected:\n%s", cloned.String(), original.String())
+		t.FailNow()
+	}
+
+	if !clone.Equal(tree, nil) {
+		t.Errorf("clone must equal to the original")
+		t.FailNow()
+	}
+
+	// independent of the original
+	clone.Remove(testKey(55))
+	clone.Insert(&testElem{1000})
+	clone.Insert(&testElem{1001})
+
+	if _, ok, _ := tree.SearchElem(testKey(55)); !ok {
+		t.Errorf("removing from clone must not affect the original")
+		t.FailNow()
+	}
+
+	if tree.Len() != 100 || clone.Len() != 100 {
+		t.Errorf("original has %d and clone has %d elements, expected 100 each", tree.Len(), clone.Len())
+		t.FailNow()
+	}
+
+	// recency is carried over: 1 is the least recently used, not 0
+	if _, ok, _ := clone.SearchElem(testKey(1)); ok {
+		t.Errorf("the least recently used element must be evicted from clone")
+		t.FailNow()
+	}
+
+	if _, ok, _ := clone.SearchElem(testKey(0)); !ok {
+		t.Errorf("the most recently used element must stay in clone")
+		t.FailNow()
+	}
+
+	// time to live is carried over
+	clock.Advance(time.Minute)
+
+	if n := clone.ExpireNow(); n != 10 {
+		t.Errorf("%d elements expired in clone, expected 10", n)
+		t.FailNow()
+	}
+
+	for _, tr := range []*Bptree{tree, clone} {
+		err = tr.Verify()
+		if err != nil {
+			t.Errorf("invalid tree: %v", err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, _ := NewBptree(4, _maxDepth, false)
+	b, _ := NewBptree(7, _maxDepth, false)
+
+	for i := 0; i < 100; i++ {
+		a.Insert(&testElem{i})
+		b.Insert(&testElem{99 - i})
+	}
+
+	// different shapes of the same contents
+	if !a.Equal(b, nil) || !b.Equal(a, nil) {
+		t.Errorf("trees of the same elements must be equal")
+		t.FailNow()
+	}
+
+	if !a.Equal(a, nil) {
+		t.Errorf("tree must be equal to itself")
+		t.FailNow()
+	}
+
+	b.Remove(testKey(99))
+
+	if a.Equal(b, nil) || b.Equal(a, nil) {
+		t.Errorf("trees of different number of elements must not be equal")
+		t.FailNow()
+	}
+
+	b.Insert(&testElem{99})
+
+	never := func(x, y Elem) bool {
+		return false
+	}
+
+	if a.Equal(b, never) {
+		t.Errorf("elements must be compared by elemEq")
+		t.FailNow()
+	}
+}
+
+func TestCloneWhileSearching(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	tree.SetCapacity(Capacity{MaxElems: 1000, Policy: EVICT_LEAST_RECENTLY_USED})
+
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	var wg sync.WaitGroup
+
+	// searches reorder recency while clones copy it
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 2000; i++ {
+				tree.Search(testKey((i * (g + 1)) % 1000))
+			}
+		}(g)
+	}
+
+	for i := 0; i < 20; i++ {
+		clone, err := tree.Clone()
+		if err != nil {
+			t.Errorf("while cloning: %v", err)
+			t.FailNow()
+		}
+
+		if clone.Len() != 1000 || clone.recency.Len() != 1000 {
+			t.Errorf("clone must track 1000 elements, but %d of %d", clone.recency.Len(), clone.Len())
+			t.FailNow()
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestClearRunsRemoveHooks(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	// count kept by hooks
+	var count int
+
+	tree.AfterInsert(func(elem Elem) error {
+		count += 1
+		return nil
+	})
+
+	tree.AfterRemove(func(elem Elem) error {
+		count -= 1
+		return nil
+	})
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	err = tree.Clear()
+	if err != nil {
+		t.Errorf("while clearing: %v", err)
+		t.FailNow()
+	}
+
+	if count != 0 {
+		t.Errorf("count kept by hooks must be 0 after clear, but %d", count)
+		t.FailNow()
+	}
+}
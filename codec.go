@@ -0,0 +1,11 @@
+package bptree
+
+// Codec converts elements and keys to bytes and back. It is required wherever
+// tree contents leave the process, e.g. when streaming to a replica.
+type Codec interface {
+	EncodeElem(elem Elem) ([]byte, error)
+	DecodeElem(data []byte) (Elem, error)
+
+	EncodeKey(key Key) ([]byte, error)
+	DecodeKey(data []byte) (Key, error)
+}
@@ -0,0 +1,162 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// errors
+	ERR_CORRUPTED = errors.New("tree corrupted")
+)
+
+// ErrCorrupted reports an inconsistency found in the structure of the tree.
+// Once it is returned the tree is poisoned: reads are still served, but every
+// mutation fails with the same error. It matches ERR_CORRUPTED by errors.Is.
+type ErrCorrupted struct {
+	Op     string // operation in progress, e.g. "insert"
+	Reason string
+	Path   string // node where it is found, like "root/2/0", empty if unknown
+	Dump   string // nodes of the tree at the time
+}
+
+func (e *ErrCorrupted) Error() string {
+	s := fmt.Sprintf("%s: %v: %s", e.Op, ERR_CORRUPTED, e.Reason)
+
+	if e.Path != "" {
+		s += " at " + e.Path
+	}
+
+	return s
+}
+
+func (e *ErrCorrupted) Unwrap() error {
+	return ERR_CORRUPTED
+}
+
+// Poisoned returns the ErrCorrupted which poisoned the tree, or nil.
+func (tree *Bptree) Poisoned() error {
+	err := tree.poisoned.Load()
+	if err == nil {
+		return nil
+	}
+
+	return err
+}
+
+// SetRecoverPanics makes public operations recover a panic raised from inside
+// the tree, e.g. by a broken structure or a misbehaving Key, and return it as
+// ErrCorrupted instead. The tree is poisoned, so it keeps serving reads.
+func (tree *Bptree) SetRecoverPanics(enabled bool) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.recoverPanics = enabled
+}
+
+// corrupted records an inconsistency found at the end of paths, which poisons
+// the tree once the mutation in progress finishes. Caller must hold tree lock.
+func (tree *Bptree) corrupted(paths []*indexNode, format string, args ...interface{}) *ErrCorrupted {
+	err := &ErrCorrupted{
+		Reason: fmt.Sprintf(format, args...),
+		Path:   pathString(paths),
+		Dump:   dumpTree(tree),
+	}
+
+	if tree.corruption == nil {
+		tree.corruption = err
+	}
+
+	return err
+}
+
+// writable fails a mutation on poisoned tree
+func (tree *Bptree) writable() error {
+	return tree.Poisoned()
+}
+
+// mutated finishes a mutation op, caller must hold tree lock. A recovered
+// panic, a failed verification or an inconsistency recorded during the
+// mutation poisons the tree and replaces *errp.
+func (tree *Bptree) mutated(op string, errp *error) {
+	if tree.recoverPanics {
+		if r := recover(); r != nil {
+			tree.corrupted(nil, "panic: %v", r)
+		}
+	}
+
+	if tree.corruption == nil && tree.verifyOnMutation && tree.writable() == nil {
+		err := tree.verify()
+		if err != nil {
+			tree.corrupted(nil, "%v", err)
+		}
+	}
+
+	err := tree.corruption
+	if err == nil {
+		return
+	}
+
+	tree.corruption = nil
+
+	err.Op = op
+	tree.poisoned.CompareAndSwap(nil, err)
+
+	*errp = err
+}
+
+// recovered turns a panic while reading into ErrCorrupted if requested,
+// caller must hold tree lock for reading at least
+func (tree *Bptree) recovered(op string, errp *error) {
+	if !tree.recoverPanics {
+		return
+	}
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := &ErrCorrupted{
+		Op:     op,
+		Reason: fmt.Sprintf("panic: %v", r),
+		Dump:   dumpTree(tree),
+	}
+
+	tree.poisoned.CompareAndSwap(nil, err)
+
+	if errp != nil {
+		*errp = err
+	}
+}
+
+// pathString describes the node at the end of paths by child indexes from root
+func pathString(paths []*indexNode) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	s := "root"
+
+	for i := 1; i < len(paths); i++ {
+		s += fmt.Sprintf("/%d", paths[i-1].childIndex(paths[i]))
+	}
+
+	return s
+}
+
+// dumpTree prints nodes as far as possible, as broken tree may panic on it
+func dumpTree(tree *Bptree) (s string) {
+	buf := new(strings.Builder)
+
+	defer func() {
+		if r := recover(); r != nil {
+			s = buf.String() + fmt.Sprintf("(dump aborted: %v)\n", r)
+		}
+	}()
+
+	printNodes(tree, buf, false)
+
+	return buf.String()
+}
@@ -0,0 +1,103 @@
+package bptree
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestCorruptibleTree(t *testing.T) *Bptree {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	return tree
+}
+
+func checkPoisoned(t *testing.T, tree *Bptree, corrupted error) {
+	if tree.Poisoned() != corrupted {
+		t.Errorf("tree must be poisoned by %v, but %v", corrupted, tree.Poisoned())
+	}
+
+	err := tree.Insert(&testElem{1000})
+	if err != corrupted {
+		t.Errorf("poisoned tree must reject insertion, but %v", err)
+	}
+
+	err = tree.Remove(testKey(1))
+	if err != corrupted {
+		t.Errorf("poisoned tree must reject removal, but %v", err)
+	}
+
+	// still readable
+	elem, ok, err := tree.SearchElem(testKey(1))
+	if err != nil || !ok || elem.(*testElem).val != 1 {
+		t.Errorf("poisoned tree must serve reads, but %v %v %v", elem, ok, err)
+	}
+}
+
+func TestCorruptedOnRemove(t *testing.T) {
+	tree := newTestCorruptibleTree(t)
+
+	// leave only the left-most path, so underflowed nodes have no sibling
+	for _, node := range tree.edgePath(ToLeft) {
+		if node.isInternal {
+			node.children = node.children[:1]
+		}
+	}
+
+	err := tree.Remove(testKey(0))
+
+	var corrupted *ErrCorrupted
+
+	if !errors.As(err, &corrupted) || !errors.Is(err, ERR_CORRUPTED) {
+		t.Errorf("removal must fail with ErrCorrupted, but %v", err)
+		t.FailNow()
+	}
+
+	if corrupted.Op != "remove" || corrupted.Path == "" || corrupted.Dump == "" {
+		t.Errorf("corruption must be described: %#v", corrupted)
+	}
+
+	checkPoisoned(t, tree, err)
+}
+
+func TestCorruptedOnVerify(t *testing.T) {
+	tree := newTestCorruptibleTree(t)
+	tree.SetVerifyOnMutation(true)
+
+	// miscount elements
+	tree.count += 1
+
+	err := tree.Insert(&testElem{200})
+	if !errors.Is(err, ERR_CORRUPTED) {
+		t.Errorf("violation must fail mutation with ErrCorrupted, but %v", err)
+		t.FailNow()
+	}
+
+	checkPoisoned(t, tree, err)
+}
+
+func TestCorruptedRecoverPanics(t *testing.T) {
+	tree := newTestCorruptibleTree(t)
+	tree.SetRecoverPanics(true)
+
+	leaf := tree.firstLeaf()
+	leaf.children[0] = (*testElem)(nil)
+
+	_, _, err := tree.Search(testKey(0))
+	if !errors.Is(err, ERR_CORRUPTED) {
+		t.Errorf("panic while searching must be recovered, but %v", err)
+		t.FailNow()
+	}
+
+	err = tree.Insert(&testElem{1000})
+	if err != tree.Poisoned() {
+		t.Errorf("poisoned tree must reject insertion, but %v", err)
+	}
+}
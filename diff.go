@@ -0,0 +1,182 @@
+package bptree
+
+import (
+	"iter"
+)
+
+type ChangeKind int
+
+const (
+	CHANGE_ADDED   ChangeKind = iota // in b only
+	CHANGE_REMOVED                   // in a only
+	CHANGE_CHANGED                   // in both, but not equal
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case CHANGE_ADDED:
+		return "added"
+	case CHANGE_REMOVED:
+		return "removed"
+	case CHANGE_CHANGED:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a difference of b from a. Old is the element of a and New is the
+// one of b, nil if the tree doesn't have it.
+type Change struct {
+	Kind ChangeKind
+	Key  Key
+	Old  Elem
+	New  Elem
+}
+
+// diffBatch is the least number of elements Diff reads from a tree at once
+const diffBatch = 128
+
+// Diff yields changes turning alive elements of a into those of b in order of
+// keys. Elements of a key in both trees are changed unless elemEq holds for
+// them; nil elemEq compares keys only. With overlapped keys, elements of a
+// key are paired in order and the rest are added or removed.
+//
+// Leaves of both trees are walked side by side, reading a batch of elements
+// from one tree at a time under its read lock. No lock is held while changes
+// are yielded, so the trees may be changed in the loop; changes of keys up to
+// the last yielded one are not seen. An error reading either tree is yielded
+// last.
+func Diff(a, b *Bptree, elemEq func(x, y Elem) bool) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		if !a.initialized || !b.initialized {
+			yield(Change{}, ERR_NOT_INITIALIZED)
+			return
+		}
+
+		olds := &diffCursor{tree: a}
+		news := &diffCursor{tree: b}
+
+		// the last key compared, trees are read after it
+		var last Key
+
+		for {
+			oldElem, err := olds.peek(last)
+			if err != nil {
+				yield(Change{}, err)
+				return
+			}
+
+			newElem, err := news.peek(last)
+			if err != nil {
+				yield(Change{}, err)
+				return
+			}
+
+			var change Change
+
+			switch {
+			case oldElem == nil && newElem == nil:
+				return
+
+			case newElem == nil || (oldElem != nil && oldElem.Key().CompareTo(newElem.Key()) == Less):
+				change = Change{Kind: CHANGE_REMOVED, Key: oldElem.Key(), Old: oldElem}
+				olds.elems = olds.elems[1:]
+
+			case oldElem == nil || oldElem.Key().CompareTo(newElem.Key()) == Greater:
+				change = Change{Kind: CHANGE_ADDED, Key: newElem.Key(), New: newElem}
+				news.elems = news.elems[1:]
+
+			default:
+				olds.elems = olds.elems[1:]
+				news.elems = news.elems[1:]
+				last = oldElem.Key()
+
+				if elemEq == nil || elemEq(oldElem, newElem) {
+					continue
+				}
+
+				change = Change{Kind: CHANGE_CHANGED, Key: oldElem.Key(), Old: oldElem, New: newElem}
+			}
+
+			last = change.Key
+
+			if !yield(change, nil) {
+				return
+			}
+		}
+	}
+}
+
+// diffCursor buffers elements of a tree read for Diff
+type diffCursor struct {
+	tree  *Bptree
+	elems Elems
+	done  bool
+}
+
+// peek returns the next element, reading elements of keys greater than last
+// if none is buffered. It returns nil once the tree runs out of elements.
+func (c *diffCursor) peek(last Key) (elem Elem, err error) {
+	if len(c.elems) == 0 && !c.done {
+		c.elems, err = c.tree.readAfter("diff", last, diffBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		c.done = len(c.elems) == 0
+	}
+
+	if len(c.elems) == 0 {
+		return nil, nil
+	}
+
+	return c.elems[0], nil
+}
+
+// readAfter returns at least n alive elements of keys greater than after in
+// order, from the first one if after is nil. Elements of the last key are all
+// included, so reading again after it misses none of them.
+func (tree *Bptree) readAfter(op string, after Key, n int) (elems Elems, err error) {
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	defer tree.recovered(op, &err)
+
+	if tree.root == nil {
+		return nil, nil
+	}
+
+	// start from right before the first candidate, so the first move lands on it
+	node, i := tree.head, -1
+	if after != nil {
+		paths, idx := tree.findFirst(after)
+		node, i = paths[len(paths)-1], idx-1
+	}
+
+	now := tree.now()
+
+	for {
+		node, i = nextPos(node, i)
+		if node == nil {
+			return elems, nil
+		}
+
+		elem, ok := tree.visible(node.children[i], now)
+		if !ok {
+			continue
+		}
+
+		key := elem.Key()
+
+		if after != nil && key.CompareTo(after) != Greater {
+			continue
+		}
+
+		if len(elems) >= n && key.CompareTo(elems[len(elems)-1].Key()) != Equal {
+			return elems, nil
+		}
+
+		elems = append(elems, elem)
+	}
+}
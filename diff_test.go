@@ -0,0 +1,214 @@
+package bptree
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	a := newSetOpsTree(t, 4, nil, "")
+	b := newSetOpsTree(t, 7, nil, "")
+
+	for key := 0; key < 500; key++ {
+		switch r.Intn(4) {
+		case 0:
+			a.Insert(&taggedElem{key, "a"})
+		case 1:
+			b.Insert(&taggedElem{key, "b"})
+		case 2:
+			a.Insert(&taggedElem{key, "a"})
+			b.Insert(&taggedElem{key, "b"})
+		default:
+			a.Insert(&taggedElem{key, "same"})
+			b.Insert(&taggedElem{key, "same"})
+		}
+	}
+
+	tagEq := func(x, y Elem) bool {
+		return x.(*taggedElem).tag == y.(*taggedElem).tag
+	}
+
+	var last Key
+
+	// applying changes to a turns it into b
+	for change, err := range Diff(a, b, tagEq) {
+		if err != nil {
+			t.Errorf("while diffing: %v", err)
+			t.FailNow()
+		}
+
+		if last != nil && last.CompareTo(change.Key) != Less {
+			t.Errorf("change of %v follows %v out of order", change.Key, last)
+			t.FailNow()
+		}
+
+		last = change.Key
+
+		switch change.Kind {
+		case CHANGE_ADDED:
+			if change.Old != nil || change.New.(*taggedElem).tag != "b" {
+				t.Errorf("invalid added change %+v", change)
+				t.FailNow()
+			}
+
+			a.Insert(change.New)
+
+		case CHANGE_REMOVED:
+			if change.New != nil || change.Old.(*taggedElem).tag != "a" {
+				t.Errorf("invalid removed change %+v", change)
+				t.FailNow()
+			}
+
+			a.Remove(change.Key)
+
+		case CHANGE_CHANGED:
+			if change.Old.(*taggedElem).tag != "a" || change.New.(*taggedElem).tag != "b" {
+				t.Errorf("invalid changed change %+v", change)
+				t.FailNow()
+			}
+
+			a.Remove(change.Key)
+			a.Insert(change.New)
+		}
+	}
+
+	if !a.Equal(b, tagEq) {
+		t.Errorf("applying diff must make trees equal")
+		t.FailNow()
+	}
+
+	for change, err := range Diff(a, b, tagEq) {
+		t.Errorf("equal trees must have no change, but %+v: %v", change, err)
+		t.FailNow()
+	}
+}
+
+func TestDiffBreak(t *testing.T) {
+	a := newSetOpsTree(t, 4, []int{1, 2, 3, 4, 5}, "a")
+	b := newSetOpsTree(t, 4, []int{3, 4, 5, 6, 7}, "b")
+
+	var kinds []ChangeKind
+
+	// keys only without elemEq
+	for change, err := range Diff(a, b, nil) {
+		if err != nil {
+			t.Errorf("while diffing: %v", err)
+			t.FailNow()
+		}
+
+		kinds = append(kinds, change.Kind)
+		if len(kinds) == 3 {
+			break
+		}
+	}
+
+	expected := []ChangeKind{CHANGE_REMOVED, CHANGE_REMOVED, CHANGE_ADDED}
+
+	if len(kinds) != len(expected) || kinds[0] != expected[0] || kinds[1] != expected[1] || kinds[2] != expected[2] {
+		t.Errorf("changes %v, expected %v", kinds, expected)
+		t.FailNow()
+	}
+}
+
+func TestDiffOverlapped(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	a, _ := New(WithDegree(4), WithDuplicates(DUPLICATE_ALLOW))
+	b, _ := New(WithDegree(5), WithDuplicates(DUPLICATE_ALLOW))
+
+	counts := make(map[int][2]int)
+
+	// groups of a key longer than a batch read at once
+	for key := 0; key < 10; key++ {
+		n := [2]int{r.Intn(3 * diffBatch), r.Intn(3 * diffBatch)}
+
+		for i := 0; i < n[0]; i++ {
+			a.Insert(&testElem{key})
+		}
+
+		for i := 0; i < n[1]; i++ {
+			b.Insert(&testElem{key})
+		}
+
+		counts[key] = n
+	}
+
+	for change, err := range Diff(a, b, nil) {
+		if err != nil {
+			t.Errorf("while diffing: %v", err)
+			t.FailNow()
+		}
+
+		key := int(change.Key.(testKey))
+		n := counts[key]
+
+		switch change.Kind {
+		case CHANGE_ADDED:
+			n[1] -= 1
+		case CHANGE_REMOVED:
+			n[0] -= 1
+		default:
+			t.Errorf("keys only must not be changed, but %+v", change)
+			t.FailNow()
+		}
+
+		counts[key] = n
+	}
+
+	// paired ones are left equal
+	for key, n := range counts {
+		if n[0] != n[1] {
+			t.Errorf("key %d is left %d and %d", key, n[0], n[1])
+			t.FailNow()
+		}
+	}
+}
+
+func TestDiffError(t *testing.T) {
+	a := newSetOpsTree(t, 4, []int{1, 2, 3}, "a")
+	b := newSetOpsTree(t, 4, nil, "b")
+
+	for key := 0; key < 1000; key++ {
+		b.Insert(&taggedElem{key, "b"})
+	}
+
+	b.SetRecoverPanics(true)
+
+	// corrupt an element beyond the first batch
+	for node := b.firstLeaf(); node != nil; node = node.next {
+		if node.children[0].Key().CompareTo(testKey(500)) != Less {
+			node.children[0] = (*testElem)(nil)
+			break
+		}
+	}
+
+	var changes int
+	var last error
+
+	for _, err := range Diff(a, b, nil) {
+		if last != nil {
+			t.Errorf("nothing must follow error %v", last)
+			t.FailNow()
+		}
+
+		if err != nil {
+			last = err
+			continue
+		}
+
+		changes += 1
+	}
+
+	if !errors.Is(last, ERR_CORRUPTED) {
+		t.Errorf("corrupted tree must be reported, but %v", last)
+		t.FailNow()
+	}
+
+	if changes == 0 {
+		t.Errorf("changes before corruption must be yielded")
+		t.FailNow()
+	}
+}
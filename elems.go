@@ -28,7 +28,7 @@ func (elems Elems) find(key Key) (idx int, isEqual bool) {
 	return
 }
 
-func (elems Elems) insert(elem Elem, maxDegree int, allowOverlap bool) (Elems, error) {
+func (elems Elems) insert(elem Elem, maxChildren int, allowOverlap bool) (Elems, error) {
 	idx, equal := elems.find(elem.Key())
 
 	if idx >= len(elems) {
@@ -40,7 +40,7 @@ func (elems Elems) insert(elem Elem, maxDegree int, allowOverlap bool) (Elems, e
 		return nil, ERR_OVERLAPPED
 	}
 
-	newElems := make(Elems, len(elems)+1, maxDegree+1)
+	newElems := make(Elems, len(elems)+1, maxChildren+1)
 
 	copy(newElems, elems[:idx])
 	newElems[idx] = elem
@@ -49,20 +49,10 @@ func (elems Elems) insert(elem Elem, maxDegree int, allowOverlap bool) (Elems, e
 	return newElems, nil
 }
 
-func (elems Elems) delete(key Key, maxDegree int) (Elems, bool) {
-	idx, equal := elems.find(key)
-
-	if equal {
-		// found
-		newElems := make(Elems, len(elems)-1, maxDegree+1)
-
-		copy(newElems, elems[:idx])
-		copy(newElems[idx:], elems[idx+1:])
-
-		return newElems, true
+func (elems Elems) reverse() {
+	for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+		elems[i], elems[j] = elems[j], elems[i]
 	}
-
-	return elems, false
 }
 
 func (elems Elems) String() string {
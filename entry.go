@@ -0,0 +1,106 @@
+package bptree
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// entry wraps an element the tree keeps bookkeeping for. Leaves hold either
+// plain elements or entries, and entries never leave the tree.
+type entry struct {
+	Elem
+
+	deadline int64         // unix nano to expire at, zero if never
+	access   *list.Element // position in recency list, nil if not tracked
+	digest   digest        // of encoded element, if the tree is hashed
+}
+
+func (e *entry) String() string {
+	if e.deadline != 0 {
+		return fmt.Sprintf("%v(ttl)", e.Elem)
+	}
+
+	return fmt.Sprintf("%v", e.Elem)
+}
+
+func unwrapElem(elem Elem) Elem {
+	if e, ok := elem.(*entry); ok {
+		return e.Elem
+	}
+
+	return elem
+}
+
+// wrap returns an entry for elem if the tree needs to keep track of it
+func (tree *Bptree) wrap(elem Elem) Elem {
+	if _, ok := elem.(*entry); ok || (tree.recency == nil && !tree.hashing) {
+		return elem
+	}
+
+	return &entry{Elem: elem}
+}
+
+// totalsOf sums up bookkeeping of elements in sub-tree of node out of its
+// children
+func (tree *Bptree) totalsOf(node *indexNode) (count int, bytes int64, expiring int) {
+	for _, child := range node.children {
+		if c, ok := child.(*indexNode); ok {
+			count += c.count
+			bytes += c.bytes
+			expiring += c.expiring
+
+			continue
+		}
+
+		count += 1
+
+		if tree.capacity.SizeOf != nil {
+			bytes += int64(tree.capacity.SizeOf(unwrapElem(child)))
+		}
+
+		if e, ok := child.(*entry); ok && e.deadline != 0 {
+			expiring += 1
+		}
+	}
+
+	return
+}
+
+// account keeps bookkeeping of elements stored into(+1) or removed from(-1)
+// leaves, caller must hold tree lock
+func (tree *Bptree) account(elem Elem, delta int) {
+	tree.count += delta
+
+	if tree.journal != nil {
+		if delta > 0 {
+			tree.journal(msgInsert, elem)
+		} else {
+			tree.journal(msgRemove, elem)
+		}
+	}
+
+	if tree.capacity.SizeOf != nil {
+		tree.bytes += int64(delta * tree.capacity.SizeOf(unwrapElem(elem)))
+	}
+
+	e, ok := elem.(*entry)
+	if !ok {
+		return
+	}
+
+	if e.deadline != 0 {
+		tree.expiring += delta
+
+		if delta > 0 && (tree.nextDeadline == 0 || e.deadline < tree.nextDeadline) {
+			tree.nextDeadline = e.deadline
+		}
+	}
+
+	switch {
+	case delta > 0 && tree.recency != nil:
+		e.access = tree.recency.PushFront(e)
+	case delta < 0 && e.access != nil:
+		tree.recency.Remove(e.access)
+		e.access = nil
+	}
+}
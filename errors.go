@@ -0,0 +1,33 @@
+package bptree
+
+import (
+	"fmt"
+)
+
+// KeyError records which operation on which key failed. Err is one of ERR_*
+// values, so errors.Is matches it as well.
+type KeyError struct {
+	Op  string // e.g. "insert"
+	Key Key
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s %v: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// keyError wraps err into KeyError if it is about key, others like errors of
+// hooks are returned as they are
+func keyError(op string, key Key, err error) error {
+	switch err {
+	case ERR_EMPTY, ERR_NOT_FOUND, ERR_OVERLAPPED, ERR_EXCEED_MAX_DEPTH,
+		ERR_SEARCH_OVERFLOWED, ERR_SEARCH_UNDERFLOWED, ERR_NOT_INTERVAL:
+		return &KeyError{Op: op, Key: key, Err: err}
+	}
+
+	return err
+}
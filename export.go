@@ -0,0 +1,315 @@
+package bptree
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportOptions restricts nodes written by WriteDOT and WriteJSON. Zero value
+// writes the whole tree.
+type ExportOptions struct {
+	MaxDepth int // number of levels from root, zero for all
+	From, To Key // nodes having keys in [From, To] only, nil for unbounded
+}
+
+// JSONTree is the schema of WriteJSON. Keys are formatted by fmt.
+type JSONTree struct {
+	Height       int        `json:"height"`
+	MaxDegree    int        `json:"maxDegree"` // of internal nodes
+	LeafCapacity int        `json:"leafCapacity"`
+	Elems        int        `json:"elems"`
+	Nodes        []JSONNode `json:"nodes"` // level by level from root, in order
+}
+
+// JSONNode is a node written by WriteJSON. ID is "n<depth>_<index in the
+// level>", which stays the same for the same shape of tree.
+type JSONNode struct {
+	ID       string   `json:"id"`
+	Depth    int      `json:"depth"` // from root
+	Leaf     bool     `json:"leaf"`
+	Keys     []string `json:"keys"`     // separators or keys of elements
+	Children []string `json:"children"` // exported children only
+	Prev     string   `json:"prev,omitempty"`
+	Next     string   `json:"next,omitempty"`
+}
+
+// exportedNode is a node to export with its id and depth from root
+type exportedNode struct {
+	*indexNode
+
+	id    string
+	depth int
+}
+
+type exporter struct {
+	tree *Bptree
+	opts ExportOptions
+
+	ids      map[*indexNode]string
+	nodes    []exportedNode
+	exported map[*indexNode]bool
+}
+
+// newExporter collects nodes to export, caller must hold tree lock
+func newExporter(tree *Bptree, opts ExportOptions) *exporter {
+	e := &exporter{
+		tree:     tree,
+		opts:     opts,
+		ids:      make(map[*indexNode]string),
+		exported: make(map[*indexNode]bool),
+	}
+
+	// name every node by its position in the level
+	for depth, nodes := range tree.levels() {
+		for i, node := range nodes {
+			e.ids[node] = fmt.Sprintf("n%d_%d", depth, i)
+		}
+	}
+
+	if tree.root != nil {
+		e.collect(tree.root, 0)
+	}
+
+	// level by level
+	levels := make([][]exportedNode, 0)
+
+	for _, node := range e.nodes {
+		for len(levels) <= node.depth {
+			levels = append(levels, nil)
+		}
+
+		levels[node.depth] = append(levels[node.depth], node)
+	}
+
+	e.nodes = e.nodes[:0]
+
+	for _, nodes := range levels {
+		e.nodes = append(e.nodes, nodes...)
+	}
+
+	return e
+}
+
+func (e *exporter) collect(node *indexNode, depth int) {
+	if e.opts.MaxDepth > 0 && depth >= e.opts.MaxDepth {
+		return
+	}
+
+	if !e.inRange(node) {
+		return
+	}
+
+	e.nodes = append(e.nodes, exportedNode{indexNode: node, id: e.ids[node], depth: depth})
+	e.exported[node] = true
+
+	if !node.isInternal {
+		return
+	}
+
+	for _, child := range node.children {
+		e.collect(child.(*indexNode), depth+1)
+	}
+}
+
+// inRange reports whether keys of sub-tree of node overlap the range
+func (e *exporter) inRange(node *indexNode) bool {
+	if len(node.children) == 0 {
+		return true
+	}
+
+	if e.opts.To != nil && node.Key().CompareTo(e.opts.To) == Greater {
+		return false
+	}
+
+	if e.opts.From != nil {
+		last := node
+
+		for last.isInternal {
+			last = last.children[len(last.children)-1].(*indexNode)
+		}
+
+		if last.children[len(last.children)-1].Key().CompareTo(e.opts.From) == Less {
+			return false
+		}
+	}
+
+	return true
+}
+
+// keys returns separators of internal node or keys of elements of leaf
+func (e *exporter) keys(node *indexNode) []string {
+	keys := make([]string, len(node.children))
+
+	for i, child := range node.children {
+		keys[i] = fmt.Sprintf("%v", child.Key())
+	}
+
+	return keys
+}
+
+// levels returns nodes of each depth from root in order, caller must hold
+// tree lock
+func (tree *Bptree) levels() (levels [][]*indexNode) {
+	for first := tree.root; first != nil; {
+		var nodes []*indexNode
+
+		for node := first; node != nil; node = node.next {
+			nodes = append(nodes, node)
+		}
+
+		levels = append(levels, nodes)
+
+		if !first.isInternal || len(first.children) == 0 {
+			break
+		}
+
+		first = first.children[0].(*indexNode)
+	}
+
+	return
+}
+
+// WriteJSON writes structure of the tree in the schema of JSONTree.
+func (tree *Bptree) WriteJSON(w io.Writer, opts ExportOptions) error {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	e := newExporter(tree, opts)
+
+	out := JSONTree{
+		MaxDegree:    tree.maxDegree,
+		LeafCapacity: tree.leafCapacity,
+		Elems:        tree.count,
+		Nodes:        make([]JSONNode, 0, len(e.nodes)),
+	}
+
+	if tree.root != nil {
+		out.Height = tree.root.depthToLeaf + 1
+	}
+
+	for _, node := range e.nodes {
+		jn := JSONNode{
+			ID:       node.id,
+			Depth:    node.depth,
+			Leaf:     !node.isInternal,
+			Keys:     e.keys(node.indexNode),
+			Children: make([]string, 0),
+		}
+
+		if node.isInternal {
+			for _, child := range node.children {
+				if e.exported[child.(*indexNode)] {
+					jn.Children = append(jn.Children, e.ids[child.(*indexNode)])
+				}
+			}
+		}
+
+		if node.prev != nil {
+			jn.Prev = e.ids[node.prev]
+		}
+
+		if node.next != nil {
+			jn.Next = e.ids[node.next]
+		}
+
+		out.Nodes = append(out.Nodes, jn)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
+
+// WriteDOT writes structure of the tree in Graphviz DOT language. Internal
+// nodes are records of separators with edges to children, and leaves are
+// records of keys linked by next and prev.
+func (tree *Bptree) WriteDOT(w io.Writer, opts ExportOptions) error {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	e := newExporter(tree, opts)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph bptree {")
+	fmt.Fprintln(bw, "\tnode [shape=record];")
+
+	depth := -1
+
+	for i, node := range e.nodes {
+		// keep levels in rows
+		if node.depth != depth {
+			if depth >= 0 {
+				fmt.Fprintln(bw, "\t}")
+			}
+
+			depth = node.depth
+			fmt.Fprintln(bw, "\t{ rank=same;")
+		}
+
+		fields := e.keys(node.indexNode)
+		for j, field := range fields {
+			fields[j] = fmt.Sprintf("<c%d> %s", j, dotEscape(field))
+		}
+
+		fmt.Fprintf(bw, "\t\t%s [label=\"%s\"];\n", node.id, strings.Join(fields, "|"))
+
+		if i == len(e.nodes)-1 {
+			fmt.Fprintln(bw, "\t}")
+		}
+	}
+
+	for _, node := range e.nodes {
+		if !node.isInternal {
+			// links between leaves
+			if node.next != nil && e.exported[node.next] {
+				fmt.Fprintf(bw, "\t%s -> %s [style=dashed, constraint=false, label=\"next\"];\n", node.id, e.ids[node.next])
+			}
+
+			if node.prev != nil && e.exported[node.prev] {
+				fmt.Fprintf(bw, "\t%s -> %s [style=dotted, constraint=false, label=\"prev\"];\n", node.id, e.ids[node.prev])
+			}
+
+			continue
+		}
+
+		for j, child := range node.children {
+			if e.exported[child.(*indexNode)] {
+				fmt.Fprintf(bw, "\t%s:c%d -> %s;\n", node.id, j, e.ids[child.(*indexNode)])
+			}
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// dotEscape escapes characters having meanings in record labels
+func dotEscape(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '|', '{', '}', '<', '>', ' ':
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
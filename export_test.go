@@ -0,0 +1,140 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	tree, err := NewBptree(3, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 1; i <= 3; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = tree.WriteDOT(buf, ExportOptions{})
+	if err != nil {
+		t.Errorf("while writing dot: %v", err)
+		t.FailNow()
+	}
+
+	expected := `digraph bptree {
+	node [shape=record];
+	{ rank=same;
+		n0_0 [label="<c0> 1|<c1> 2"];
+	}
+	{ rank=same;
+		n1_0 [label="<c0> 1"];
+		n1_1 [label="<c0> 2|<c1> 3"];
+	}
+	n0_0:c0 -> n1_0;
+	n0_0:c1 -> n1_1;
+	n1_0 -> n1_1 [style=dashed, constraint=false, label="next"];
+	n1_1 -> n1_0 [style=dotted, constraint=false, label="prev"];
+}
+`
+
+	if buf.String() != expected {
+		t.Errorf("unexpected dot:\n%s", buf.String())
+	}
+}
+
+func writeTestJSON(t *testing.T, tree *Bptree, opts ExportOptions) JSONTree {
+	buf := new(bytes.Buffer)
+
+	err := tree.WriteJSON(buf, opts)
+	if err != nil {
+		t.Errorf("while writing json: %v", err)
+		t.FailNow()
+	}
+
+	var out JSONTree
+
+	err = json.Unmarshal(buf.Bytes(), &out)
+	if err != nil {
+		t.Errorf("while reading json: %v", err)
+		t.FailNow()
+	}
+
+	return out
+}
+
+func TestWriteJSON(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	stats := tree.Stats()
+
+	out := writeTestJSON(t, tree, ExportOptions{})
+
+	if out.Height != stats.Height || out.Elems != 100 || len(out.Nodes) != stats.LeafNodes+stats.InternalNodes {
+		t.Errorf("unexpected tree: height %d, %d elements, %d nodes", out.Height, out.Elems, len(out.Nodes))
+	}
+
+	ids := make(map[string]bool)
+	var keys int
+
+	for _, node := range out.Nodes {
+		ids[node.ID] = true
+
+		if node.Leaf {
+			keys += len(node.Keys)
+		} else if len(node.Children) != len(node.Keys) {
+			t.Errorf("node %s must have a separator for each child", node.ID)
+		}
+	}
+
+	if out.Nodes[0].ID != "n0_0" || keys != 100 {
+		t.Errorf("unexpected nodes: root %s, %d keys", out.Nodes[0].ID, keys)
+	}
+
+	for _, node := range out.Nodes {
+		for _, child := range node.Children {
+			if !ids[child] {
+				t.Errorf("child %s of %s is not exported", child, node.ID)
+			}
+		}
+	}
+
+	// depth limit
+	out = writeTestJSON(t, tree, ExportOptions{MaxDepth: 2})
+
+	if len(out.Nodes) != stats.LevelNodes[0]+stats.LevelNodes[1] {
+		t.Errorf("only two levels must be exported, but %d nodes", len(out.Nodes))
+	}
+
+	// key range
+	out = writeTestJSON(t, tree, ExportOptions{From: testKey(40), To: testKey(45)})
+
+	keys = 0
+
+	for _, node := range out.Nodes {
+		if !node.Leaf {
+			continue
+		}
+
+		keys += len(node.Keys)
+
+		if node.Keys[len(node.Keys)-1] < "40" || node.Keys[0] > "45" {
+			t.Errorf("leaf %s is out of range: %v", node.ID, node.Keys)
+		}
+	}
+
+	if keys < 6 || keys > 6+4 {
+		t.Errorf("leaves in range must have 6 keys and a few more, but %d", keys)
+	}
+}
@@ -0,0 +1,151 @@
+package bptree
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// decodeModelOps makes a tree configuration and ops out of arbitrary bytes:
+// degree and overlap from the first two bytes, then three bytes for each op
+func decodeModelOps(data []byte) (maxDegree int, allowOverlap bool, ops []modelOp) {
+	maxDegree = 3
+	if len(data) > 0 {
+		maxDegree += int(data[0] % 14)
+	}
+
+	if len(data) > 1 {
+		allowOverlap = data[1]&1 == 1
+	}
+
+	for i := 2; i+3 <= len(data) && len(ops) < 512; i += 3 {
+		b0, b1, b2 := data[i], data[i+1], data[i+2]
+
+		op := modelOp{
+			kind:      modelOpKind(int(b0&0x7f) % int(numModelOpKinds)),
+			key:       int(b1 % 64),
+			direction: ToRight,
+			offset:    int(int8(b2)) % 8,
+			to:        int(b2 % 64),
+			maxN:      int(b2 % 10),
+		}
+
+		if b0&0x80 != 0 {
+			op.direction = ToLeft
+		}
+
+		ops = append(ops, op)
+	}
+
+	return
+}
+
+func encodeModelOps(maxDegree int, allowOverlap bool, ops ...modelOp) []byte {
+	data := []byte{byte(maxDegree - 3), 0}
+	if allowOverlap {
+		data[1] = 1
+	}
+
+	for _, op := range ops {
+		b0 := byte(op.kind)
+		if op.direction == ToLeft {
+			b0 |= 0x80
+		}
+
+		data = append(data, b0, byte(op.key), byte(op.offset))
+	}
+
+	return data
+}
+
+func FuzzOps(f *testing.F) {
+	var inserts []modelOp
+	for i := 0; i < 20; i++ {
+		inserts = append(inserts, modelOp{kind: MODEL_INSERT, key: i * 2})
+	}
+
+	// nearby to left from a key between leaves, which has stepped twice
+	// to the previous leaf
+	f.Add(encodeModelOps(3, false, append(inserts,
+		modelOp{kind: MODEL_SEARCH_NEARBY, key: 5, direction: ToLeft},
+		modelOp{kind: MODEL_ELEM_RANGE, key: 7, direction: ToLeft, offset: -3})...))
+
+	// overlapped keys spread over leaves, then removed
+	f.Add(encodeModelOps(4, true, append(inserts,
+		modelOp{kind: MODEL_INSERT, key: 8}, modelOp{kind: MODEL_INSERT, key: 8},
+		modelOp{kind: MODEL_INSERT, key: 8}, modelOp{kind: MODEL_REMOVE, key: 8},
+		modelOp{kind: MODEL_ELEM_AT, key: 8, offset: 2})...))
+
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		maxDegree, allowOverlap, ops := decodeModelOps(data)
+
+		err := runModel(maxDegree, allowOverlap, ops)
+		if err != nil {
+			ops = shrinkModelOps(ops, func(ops []modelOp) bool {
+				return runModel(maxDegree, allowOverlap, ops) != nil
+			})
+
+			t.Fatalf("degree %d, overlap %v: %v\nminimal reproduction:\n%s",
+				maxDegree, allowOverlap, runModel(maxDegree, allowOverlap, ops), formatModelOps(ops))
+		}
+	})
+}
+
+// testStream feeds a replica with bytes from a primary, discarding what the
+// replica writes
+type testStream struct {
+	io.Reader
+	io.Writer
+}
+
+func FuzzReplicaStream(f *testing.F) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		f.Fatalf("while creating bptree: %v", err)
+	}
+
+	primary, err := NewPrimary(tree, testCodec{}, 16)
+	if err != nil {
+		f.Fatalf("while creating primary: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	// snapshot followed by log entries
+	buf := new(bytes.Buffer)
+
+	err = primary.writeSnapshot(buf, primary.snapshot(), 10)
+	if err != nil {
+		f.Fatalf("while writing snapshot: %v", err)
+	}
+
+	key, _ := testCodec{}.EncodeKey(testKey(3))
+	writeFrame(buf, msgInsert, append(appendUvarints(nil, 11, 0), key...))
+	writeFrame(buf, msgRemove, append(appendUvarints(nil, 12), key...))
+
+	f.Add(buf.Bytes())
+	f.Add([]byte{msgResume, 2, 0, 0})
+	f.Add([]byte{msgSnapshot, 3, 0, 0, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		replica, err := NewReplica(4, _maxDepth, false, testCodec{})
+		if err != nil {
+			t.Fatalf("while creating replica: %v", err)
+		}
+
+		// stream always ends, so does Follow
+		err = replica.Follow(testStream{bytes.NewReader(data), io.Discard})
+		if err == nil {
+			t.Fatalf("follow must fail at the end of stream")
+		}
+
+		err = replica.current().Verify()
+		if err != nil {
+			t.Fatalf("replica is broken: %v", err)
+		}
+	})
+}
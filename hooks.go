@@ -0,0 +1,86 @@
+package bptree
+
+import (
+	"reflect"
+)
+
+// Hook is called with the element being inserted or removed. Hooks run while
+// the tree is write locked, so they must not call methods of the tree.
+// A Before hook returning an error vetoes the operation; an After hook
+// returning an error rolls the operation back. The error is returned to the
+// caller in both cases. Elements removed on expiration, eviction or Clear
+// run After remove hooks only, whose errors are ignored since those removals
+// can't be rolled back.
+type Hook func(elem Elem) error
+
+type hooks struct {
+	beforeInsert []Hook
+	afterInsert  []Hook
+	beforeRemove []Hook
+	afterRemove  []Hook
+}
+
+func (h *hooks) run(list []Hook, elem Elem) error {
+	for _, hook := range list {
+		err := hook(elem)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notify runs every hook of list ignoring their errors, for removals which
+// can't be vetoed nor rolled back
+func (h *hooks) notify(list []Hook, elems Elems) {
+	for _, elem := range elems {
+		for _, hook := range list {
+			hook(elem)
+		}
+	}
+}
+
+func (tree *Bptree) BeforeInsert(hook Hook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.hooks.beforeInsert = append(tree.hooks.beforeInsert, hook)
+}
+
+func (tree *Bptree) AfterInsert(hook Hook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.hooks.afterInsert = append(tree.hooks.afterInsert, hook)
+}
+
+func (tree *Bptree) BeforeRemove(hook Hook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.hooks.beforeRemove = append(tree.hooks.beforeRemove, hook)
+}
+
+func (tree *Bptree) AfterRemove(hook Hook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.hooks.afterRemove = append(tree.hooks.afterRemove, hook)
+}
+
+// sameElem reports whether a and b are the very same element. Elements of
+// incomparable types can't be told apart from others of equal key.
+func sameElem(a, b Elem) bool {
+	ta := reflect.TypeOf(a)
+
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+
+	if !ta.Comparable() {
+		return true
+	}
+
+	return a == b
+}
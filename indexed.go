@@ -0,0 +1,348 @@
+package bptree
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+var (
+	// errors
+	ERR_INDEX_NOT_FOUND = errors.New("no such index")
+	ERR_INDEX_EXISTED   = errors.New("index already existed")
+)
+
+// KeyExtractor returns the key an element is looked up by in a secondary
+// index. Elements for which it returns nil are left out of the index.
+type KeyExtractor func(elem Elem) Key
+
+// IndexedTree is a primary tree accompanied by secondary indexes which are
+// kept consistent with it on every mutation.
+type IndexedTree struct {
+	primary *Bptree
+
+	indexes map[string]*secondaryIndex
+	order   []*secondaryIndex
+
+	lock *sync.RWMutex
+}
+
+type secondaryIndex struct {
+	tree    *Bptree
+	extract KeyExtractor
+	unique  bool
+}
+
+// entry of secondary tree pointing an element of primary tree
+type indexEntry struct {
+	key  Key
+	elem Elem
+}
+
+func (entry *indexEntry) Key() Key {
+	return entry.key
+}
+
+// key of non-unique secondary index, ordered by secondary key and then by
+// primary key. bound places the key before(-1) or after(1) every entry
+// having the same secondary key.
+type compositeKey struct {
+	key     Key
+	primary Key
+	bound   int
+}
+
+func (k compositeKey) CompareTo(key Key) Cond {
+	k2 := key.(compositeKey)
+
+	cond := k.key.CompareTo(k2.key)
+	if cond != Equal {
+		return cond
+	}
+
+	switch {
+	case k.bound < k2.bound:
+		return Less
+	case k.bound > k2.bound:
+		return Greater
+	case k.bound != 0:
+		return Equal
+	}
+
+	return k.primary.CompareTo(k2.primary)
+}
+
+func NewIndexedTree(maxDegree, maxDepth int, allowOverlap bool) (*IndexedTree, error) {
+	opts, err := positionalOptions(maxDegree, maxDepth, allowOverlap)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIndexedTreeWithOptions(opts...)
+}
+
+// NewIndexedTreeWithOptions makes an indexed tree whose primary tree is
+// configured by opts like New. Secondary trees take degrees and depth cap of
+// the primary only, since they hold entries rather than elements.
+func NewIndexedTreeWithOptions(opts ...Option) (*IndexedTree, error) {
+	primary, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexedTree{
+		primary: primary,
+		indexes: make(map[string]*secondaryIndex),
+		lock:    new(sync.RWMutex),
+	}, nil
+}
+
+// AddIndex defines a secondary index and fills it with elements already in
+// the tree. A unique index rejects elements whose secondary key is taken.
+func (t *IndexedTree) AddIndex(name string, extract KeyExtractor, unique bool) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.indexes[name]; ok {
+		return ERR_INDEX_EXISTED
+	}
+
+	// non-unique index distinguishes entries by primary key as well
+	duplicates := DUPLICATE_REJECT
+	if !unique {
+		duplicates = DUPLICATE_ALLOW
+	}
+
+	primary := t.primary
+
+	tree, err := New(WithInternalDegree(primary.maxDegree), WithLeafCapacity(primary.leafCapacity),
+		WithMaxDepth(primary.maxDepth), WithDuplicates(duplicates))
+	if err != nil {
+		return err
+	}
+
+	index := &secondaryIndex{
+		tree:    tree,
+		extract: extract,
+		unique:  unique,
+	}
+
+	primary.lock.RLock()
+	defer primary.lock.RUnlock()
+
+	primary.ascend(func(elem Elem) bool {
+		err = index.insert(elem)
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.indexes[name] = index
+	t.order = append(t.order, index)
+
+	return nil
+}
+
+func (t *IndexedTree) Insert(elem Elem) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.insert(elem)
+}
+
+func (t *IndexedTree) Remove(key Key) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	elem, ok, err := t.primary.SearchElem(key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return keyError("remove", key, ERR_NOT_FOUND)
+	}
+
+	return t.remove(elem)
+}
+
+// Update replaces the element having the same key as elem.
+func (t *IndexedTree) Update(elem Elem) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	old, ok, err := t.primary.SearchElem(elem.Key())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return keyError("update", elem.Key(), ERR_NOT_FOUND)
+	}
+
+	err = t.remove(old)
+	if err != nil {
+		return err
+	}
+
+	err = t.insert(elem)
+	if err != nil {
+		// put back the old one
+		t.insert(old)
+		return err
+	}
+
+	return nil
+}
+
+// insert adds elem into all trees or none of them, caller must hold t.lock
+func (t *IndexedTree) insert(elem Elem) error {
+	err := t.primary.Insert(elem)
+	if err != nil {
+		return err
+	}
+
+	for i, index := range t.order {
+		err = index.insert(elem)
+		if err != nil {
+			// roll back
+			for _, done := range t.order[:i] {
+				done.remove(elem)
+			}
+
+			t.primary.removeWhere("insert", elem.Key(), sameStored(elem))
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove deletes elem from all trees, caller must hold t.lock
+func (t *IndexedTree) remove(elem Elem) error {
+	primary := t.primary
+
+	stored, err := primary.removeWhere("remove", elem.Key(), sameStored(elem))
+	if err != nil {
+		return err
+	}
+
+	for i, index := range t.order {
+		err = index.remove(elem)
+		if err != nil {
+			// roll back
+			for _, done := range t.order[:i] {
+				done.insert(elem)
+			}
+
+			primary.restore("remove", stored)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns elements of primary tree whose key in the named index is
+// equal to key, in order of primary key.
+func (t *IndexedTree) Lookup(name string, key Key) (elems Elems, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	index, ok := t.indexes[name]
+	if !ok {
+		return nil, ERR_INDEX_NOT_FOUND
+	}
+
+	if index.unique {
+		entry, ok, err := index.tree.SearchElem(key)
+		if err != nil || !ok {
+			return nil, err
+		}
+
+		return Elems{entry.(*indexEntry).elem}, nil
+	}
+
+	res, _, err := index.tree.SearchNearby(compositeKey{key: key, bound: -1}, ToRight)
+	if err != nil {
+		if errors.Is(err, ERR_SEARCH_OVERFLOWED) || errors.Is(err, ERR_EMPTY) {
+			err = nil
+		}
+		return
+	}
+
+	entries, _ := res.ElemRangeTo(compositeKey{key: key, bound: 1}, ToRight, math.MaxInt32)
+
+	for _, entry := range entries {
+		if entry.Key().(compositeKey).key.CompareTo(key) != Equal {
+			break
+		}
+
+		elems = append(elems, entry.(*indexEntry).elem)
+	}
+
+	return
+}
+
+func (t *IndexedTree) Search(key Key) (res *SearchResult, ok bool, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.primary.Search(key)
+}
+
+func (t *IndexedTree) SearchElem(key Key) (elem Elem, ok bool, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.primary.SearchElem(key)
+}
+
+func (t *IndexedTree) SearchNearby(key Key, direction Direction) (res *SearchResult, equal bool, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.primary.SearchNearby(key, direction)
+}
+
+func (t *IndexedTree) SearchElemNearby(key Key, direction Direction) (elem Elem, equal bool, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.primary.SearchElemNearby(key, direction)
+}
+
+func (index *secondaryIndex) entryKey(elem Elem) Key {
+	key := index.extract(elem)
+	if key == nil || index.unique {
+		return key
+	}
+
+	return compositeKey{key: key, primary: elem.Key()}
+}
+
+func (index *secondaryIndex) insert(elem Elem) error {
+	key := index.entryKey(elem)
+	if key == nil {
+		return nil
+	}
+
+	return index.tree.Insert(&indexEntry{key: key, elem: elem})
+}
+
+func (index *secondaryIndex) remove(elem Elem) error {
+	key := index.entryKey(elem)
+	if key == nil {
+		return nil
+	}
+
+	_, err := index.tree.removeWhere("remove", key, func(e Elem) bool {
+		return sameElem(e.(*indexEntry).elem, elem)
+	})
+
+	return err
+}
@@ -0,0 +1,241 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testRecord struct {
+	id    int
+	group int
+	email string
+}
+
+func (rec *testRecord) Key() Key {
+	return testKey(rec.id)
+}
+
+func (rec *testRecord) String() string {
+	return fmt.Sprintf("%d/%d/%s", rec.id, rec.group, rec.email)
+}
+
+type testStringKey string
+
+func (k testStringKey) CompareTo(key Key) Cond {
+	k2 := key.(testStringKey)
+
+	switch {
+	case k < k2:
+		return Less
+	case k > k2:
+		return Greater
+	}
+
+	return Equal
+}
+
+func newTestIndexedTree(t *testing.T) *IndexedTree {
+	tree, err := NewIndexedTree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating indexed tree: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.AddIndex("group", func(elem Elem) Key {
+		return testKey(elem.(*testRecord).group)
+	}, false)
+	if err != nil {
+		t.Errorf("while adding index: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.AddIndex("email", func(elem Elem) Key {
+		email := elem.(*testRecord).email
+		if email == "" {
+			return nil
+		}
+
+		return testStringKey(email)
+	}, true)
+	if err != nil {
+		t.Errorf("while adding index: %v", err)
+		t.FailNow()
+	}
+
+	return tree
+}
+
+func checkLookup(t *testing.T, tree *IndexedTree, name string, key Key, ids ...int) {
+	elems, err := tree.Lookup(name, key)
+	if err != nil {
+		t.Errorf("while looking up %s=%v: %v", name, key, err)
+		t.FailNow()
+	}
+
+	if len(elems) != len(ids) {
+		t.Errorf("lookup %s=%v must return %v, but %v", name, key, ids, elems)
+		t.FailNow()
+	}
+
+	for i, id := range ids {
+		if elems[i].(*testRecord).id != id {
+			t.Errorf("lookup %s=%v must return %v, but %v", name, key, ids, elems)
+			t.FailNow()
+		}
+	}
+}
+
+func TestIndexedTree(t *testing.T) {
+	tree := newTestIndexedTree(t)
+
+	for i := 0; i < 100; i++ {
+		err := tree.Insert(&testRecord{id: i, group: i % 10, email: fmt.Sprintf("u%d", i)})
+		if err != nil {
+			t.Errorf("while inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	checkLookup(t, tree, "group", testKey(3), 3, 13, 23, 33, 43, 53, 63, 73, 83, 93)
+	checkLookup(t, tree, "group", testKey(10))
+	checkLookup(t, tree, "email", testStringKey("u42"), 42)
+
+	// unique violation leaves every tree untouched
+	err := tree.Insert(&testRecord{id: 100, group: 3, email: "u42"})
+	if !errors.Is(err, ERR_OVERLAPPED) {
+		t.Errorf("duplicated email must be rejected, but %v", err)
+		t.FailNow()
+	}
+
+	_, ok, _ := tree.SearchElem(testKey(100))
+	if ok {
+		t.Errorf("rejected element must not be in primary tree")
+	}
+
+	checkLookup(t, tree, "group", testKey(3), 3, 13, 23, 33, 43, 53, 63, 73, 83, 93)
+
+	// remove and update
+	err = tree.Remove(testKey(13))
+	if err != nil {
+		t.Errorf("while removing: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.Update(&testRecord{id: 23, group: 4})
+	if err != nil {
+		t.Errorf("while updating: %v", err)
+		t.FailNow()
+	}
+
+	checkLookup(t, tree, "group", testKey(3), 3, 33, 43, 53, 63, 73, 83, 93)
+	checkLookup(t, tree, "group", testKey(4), 4, 14, 23, 24, 34, 44, 54, 64, 74, 84, 94)
+	checkLookup(t, tree, "email", testStringKey("u13"))
+	checkLookup(t, tree, "email", testStringKey("u23"))
+
+	// failed update keeps the old element
+	err = tree.Update(&testRecord{id: 24, group: 4, email: "u25"})
+	if !errors.Is(err, ERR_OVERLAPPED) {
+		t.Errorf("update taking other's email must be rejected, but %v", err)
+		t.FailNow()
+	}
+
+	checkLookup(t, tree, "email", testStringKey("u24"), 24)
+	checkLookup(t, tree, "email", testStringKey("u25"), 25)
+
+	_, err = tree.Lookup("nothing", testKey(0))
+	if err != ERR_INDEX_NOT_FOUND {
+		t.Errorf("lookup of undefined index must fail, but %v", err)
+	}
+}
+
+func TestIndexedTreeBackfill(t *testing.T) {
+	tree, err := NewIndexedTree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating indexed tree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 20; i++ {
+		tree.Insert(&testRecord{id: i, group: i % 2})
+	}
+
+	err = tree.AddIndex("group", func(elem Elem) Key {
+		return testKey(elem.(*testRecord).group)
+	}, false)
+	if err != nil {
+		t.Errorf("while adding index: %v", err)
+		t.FailNow()
+	}
+
+	checkLookup(t, tree, "group", testKey(1), 1, 3, 5, 7, 9, 11, 13, 15, 17, 19)
+}
+
+func TestIndexedTreePoisoned(t *testing.T) {
+	tree := newTestIndexedTree(t)
+
+	for i := 0; i < 20; i++ {
+		tree.Insert(&testRecord{id: i, group: i % 10, email: fmt.Sprintf("u%d", i)})
+	}
+
+	corrupted := &ErrCorrupted{Op: "test", Reason: "poisoned by test"}
+	tree.primary.poisoned.Store(corrupted)
+
+	err := tree.Remove(testKey(3))
+	if err != corrupted {
+		t.Errorf("poisoned primary must reject removal, but %v", err)
+		t.FailNow()
+	}
+
+	err = tree.Update(&testRecord{id: 4, group: 5})
+	if err != corrupted {
+		t.Errorf("poisoned primary must reject update, but %v", err)
+		t.FailNow()
+	}
+
+	checkLookup(t, tree, "group", testKey(3), 3, 13)
+	checkLookup(t, tree, "group", testKey(4), 4, 14)
+
+	_, ok, _ := tree.SearchElem(testKey(3))
+	if !ok {
+		t.Errorf("poisoned primary must not be mutated")
+		t.FailNow()
+	}
+}
+
+func TestIndexedTreeWithOptions(t *testing.T) {
+	tree, err := NewIndexedTreeWithOptions(WithInternalDegree(5), WithLeafCapacity(3), WithDuplicates(DUPLICATE_ALLOW))
+	if err != nil {
+		t.Errorf("while creating indexed tree: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.AddIndex("group", func(elem Elem) Key {
+		return testKey(elem.(*testRecord).group)
+	}, false)
+	if err != nil {
+		t.Errorf("while adding index: %v", err)
+		t.FailNow()
+	}
+
+	// records of the same id are kept by the primary
+	for i := 0; i < 200; i++ {
+		err = tree.Insert(&testRecord{id: i % 100, group: i % 7})
+		if err != nil {
+			t.Errorf("while inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	elems, _ := tree.Lookup("group", testKey(3))
+	if len(elems) != 29 {
+		t.Errorf("%d records in group 3, expected 29", len(elems))
+		t.FailNow()
+	}
+
+	secondary := tree.indexes["group"].tree
+	if secondary.maxDegree != 5 || secondary.leafCapacity != 3 || secondary.maxDepth >= 0 {
+		t.Errorf("secondary tree must take degrees and depth cap of the primary")
+		t.FailNow()
+	}
+}
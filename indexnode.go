@@ -2,7 +2,6 @@ package bptree
 
 import (
 	"fmt"
-	"unsafe"
 )
 
 type indexNode struct {
@@ -15,6 +14,14 @@ type indexNode struct {
 
 	depthToLeaf int
 
+	hash digest        // of sub-tree, if the tree is hashed
+	aggs []interface{} // of sub-tree by each of monoids of the tree
+
+	// bookkeeping of elements in sub-tree
+	count    int
+	bytes    int64 // by size function of capacity
+	expiring int   // having deadline
+
 	_tmpKey Key // for temporary empty node (to be deleted soon)
 }
 
@@ -47,29 +54,56 @@ func (node *indexNode) String() string {
 		nKey = "nil"
 	}
 
-	return fmt.Sprintf("%p{c:%v, p:%v, n:%v, i:%v, d:%d}", unsafe.Pointer(node), node.children, pKey, nKey, node.isInternal, node.depthToLeaf)
+	// named by depth to leaf and smallest key, which stay the same over runs
+	return fmt.Sprintf("node(%d:%v){c:%v, p:%v, n:%v, i:%v}", node.depthToLeaf, node.Key(), node.children, pKey, nKey, node.isInternal)
 }
 
-func (node *indexNode) insertElem(elem Elem, maxDegree int, allowOverlap bool) error {
-	newChildren, err := node.children.insert(elem, maxDegree, allowOverlap)
+func (node *indexNode) insertElem(elem Elem, maxChildren int, allowOverlap bool) error {
+	newChildren, err := node.children.insert(elem, maxChildren, allowOverlap)
 	if err != nil {
 		return err
 	}
 
 	node.children = newChildren
+	node._tmpKey = nil
 	return nil
 }
 
-func (node *indexNode) deleteElem(key Key, maxDegree int) bool {
-	newChildren, ok := node.children.delete(key, maxDegree)
-	if !ok {
-		return false
-	}
+func (node *indexNode) deleteAt(idx int, maxChildren int) Elem {
+	elem := node.children[idx]
+
+	newChildren := make(Elems, len(node.children)-1, maxChildren+1)
+
+	copy(newChildren, node.children[:idx])
+	copy(newChildren[idx:], node.children[idx+1:])
 
 	if len(newChildren) < 1 {
-		node._tmpKey = key
+		node._tmpKey = elem.Key()
+	}
+
+	node.children = newChildren
+	return elem
+}
+
+// childIndex returns position of child in node by identity, as siblings may
+// share a key, or -1 if node doesn't have it
+func (node *indexNode) childIndex(child *indexNode) int {
+	for i, c := range node.children {
+		if c == Elem(child) {
+			return i
+		}
 	}
 
+	return -1
+}
+
+func (node *indexNode) insertAt(idx int, elem Elem, maxChildren int) {
+	newChildren := make(Elems, len(node.children)+1, maxChildren+1)
+
+	copy(newChildren, node.children[:idx])
+	newChildren[idx] = elem
+	copy(newChildren[idx+1:], node.children[idx:])
+
 	node.children = newChildren
-	return true
+	node._tmpKey = nil
 }
@@ -0,0 +1,122 @@
+package bptree
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// errors
+	ERR_NOT_INTERVAL      = errors.New("element is not a valid interval")
+	ERR_NOT_INTERVAL_TREE = errors.New("tree is not an interval tree")
+)
+
+// Interval is an element spanning keys from Key() to End(), both inclusive.
+// End must not be less than Key.
+type Interval interface {
+	Elem
+	End() Key
+}
+
+// WithIntervals makes the tree an interval tree, which holds Interval
+// elements only and keeps max end of intervals in every sub-tree for
+// Overlapping and Stabbing. Intervals starting at the same key need
+// DUPLICATE_ALLOW.
+func WithIntervals() Option {
+	return func(c *config) {
+		c.intervals = true
+	}
+}
+
+func validInterval(elem Elem) bool {
+	interval, ok := elem.(Interval)
+	if !ok {
+		return false
+	}
+
+	return interval.End().CompareTo(interval.Key()) != Less
+}
+
+// maxEndMonoid aggregates max end of intervals, nil for none
+var maxEndMonoid = &Monoid{
+	Combine: func(a, b interface{}) interface{} {
+		if a == nil {
+			return b
+		}
+
+		if b != nil && b.(Key).CompareTo(a.(Key)) == Greater {
+			return b
+		}
+
+		return a
+	},
+	Value: func(elem Elem) interface{} {
+		return elem.(Interval).End()
+	},
+}
+
+// maxEnd returns the max end of intervals in sub-tree of node
+func (node *indexNode) maxEnd() Key {
+	end, _ := node.aggs[len(node.aggs)-1].(Key)
+	return end
+}
+
+// Overlapping returns alive intervals overlapping [a, b] in order of their
+// starts. Sub-trees whose intervals all end before a are skipped, so it
+// visits O(log n) nodes besides those holding the result.
+func (tree *Bptree) Overlapping(a, b Key) (elems Elems, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	if tree.observer != nil {
+		defer tree.observe(OP_OVERLAPPING, a, time.Now(), &err)
+	}
+
+	defer tree.recovered("overlapping", &err)
+
+	if !tree.intervals {
+		return nil, ERR_NOT_INTERVAL_TREE
+	}
+
+	if tree.root != nil {
+		tree.overlapping(tree.root, a, b, tree.now(), &elems)
+	}
+
+	return elems, nil
+}
+
+// Stabbing returns alive intervals containing point in order of their starts.
+func (tree *Bptree) Stabbing(point Key) (Elems, error) {
+	return tree.Overlapping(point, point)
+}
+
+func (tree *Bptree) overlapping(node *indexNode, a, b Key, now int64, elems *Elems) {
+	for _, child := range node.children {
+		// the rest start after b
+		if child.Key().CompareTo(b) == Greater {
+			return
+		}
+
+		if c, ok := child.(*indexNode); ok {
+			if end := c.maxEnd(); end != nil && end.CompareTo(a) != Less {
+				tree.overlapping(c, a, b, now, elems)
+			}
+
+			continue
+		}
+
+		elem, ok := tree.visible(child, now)
+		if !ok {
+			continue
+		}
+
+		if elem.(Interval).End().CompareTo(a) != Less {
+			*elems = append(*elems, elem)
+		}
+	}
+}
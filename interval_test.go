@@ -0,0 +1,203 @@
+package bptree
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+type testInterval struct {
+	start, end int
+}
+
+func (i *testInterval) Key() Key {
+	return testKey(i.start)
+}
+
+func (i *testInterval) End() Key {
+	return testKey(i.end)
+}
+
+func TestOverlapping(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	tree, err := New(WithDegree(4), WithDuplicates(DUPLICATE_ALLOW), WithIntervals())
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	tree.SetVerifyOnMutation(true)
+
+	var intervals []*testInterval
+
+	for i := 0; i < 500; i++ {
+		start := r.Intn(1000)
+		interval := &testInterval{start, start + r.Intn(50)}
+
+		// a few long ones
+		if r.Intn(20) == 0 {
+			interval.end += r.Intn(500)
+		}
+
+		err = tree.Insert(interval)
+		if err != nil {
+			t.Errorf("while inserting %v: %v", interval, err)
+			t.FailNow()
+		}
+
+		intervals = append(intervals, interval)
+	}
+
+	// shrink the tree to have max ends go down, removing intervals of unique
+	// starts to know which one is removed
+	starts := make(map[int]int)
+	for _, interval := range intervals {
+		starts[interval.start] += 1
+	}
+
+	for removed := 0; removed < 200; {
+		j := r.Intn(len(intervals))
+		if starts[intervals[j].start] > 1 {
+			continue
+		}
+
+		err = tree.Remove(intervals[j].Key())
+		if err != nil {
+			t.Errorf("while removing %d: %v", intervals[j].start, err)
+			t.FailNow()
+		}
+
+		delete(starts, intervals[j].start)
+		intervals = append(intervals[:j], intervals[j+1:]...)
+		removed += 1
+	}
+
+	for round := 0; round < 300; round++ {
+		a := r.Intn(1100) - 50
+		b := a + r.Intn(30)
+
+		if round%3 == 0 {
+			b = a
+		}
+
+		want := make(map[*testInterval]bool)
+		for _, interval := range intervals {
+			if interval.start <= b && interval.end >= a {
+				want[interval] = true
+			}
+		}
+
+		var elems Elems
+
+		if a == b {
+			elems, err = tree.Stabbing(testKey(a))
+		} else {
+			elems, err = tree.Overlapping(testKey(a), testKey(b))
+		}
+
+		if err != nil {
+			t.Errorf("while querying [%d, %d]: %v", a, b, err)
+			t.FailNow()
+		}
+
+		if len(elems) != len(want) {
+			t.Errorf("%d intervals overlap [%d, %d], expected %d", len(elems), a, b, len(want))
+			t.FailNow()
+		}
+
+		for i, elem := range elems {
+			if !want[elem.(*testInterval)] {
+				t.Errorf("%v doesn't overlap [%d, %d]", elem, a, b)
+				t.FailNow()
+			}
+
+			if i > 0 && elems[i-1].Key().CompareTo(elem.Key()) == Greater {
+				t.Errorf("intervals must be in order of starts")
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestIntervalsRejected(t *testing.T) {
+	tree, err := New(WithIntervals())
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	err = tree.Insert(&testElem{1})
+	if !errors.Is(err, ERR_NOT_INTERVAL) {
+		t.Errorf("element not an interval must be rejected, but %v", err)
+		t.FailNow()
+	}
+
+	err = tree.Insert(&testInterval{5, 4})
+	if !errors.Is(err, ERR_NOT_INTERVAL) {
+		t.Errorf("interval ending before its start must be rejected, but %v", err)
+		t.FailNow()
+	}
+
+	plain, _ := NewBptree(4, _maxDepth, false)
+
+	_, err = plain.Stabbing(testKey(1))
+	if !errors.Is(err, ERR_NOT_INTERVAL_TREE) {
+		t.Errorf("plain tree must fail with ERR_NOT_INTERVAL_TREE, but %v", err)
+		t.FailNow()
+	}
+}
+
+func TestIntervalsAggregated(t *testing.T) {
+	spanMonoid := Monoid{
+		Identity: 0,
+		Combine: func(a, b interface{}) interface{} {
+			return a.(int) + b.(int)
+		},
+		Value: func(elem Elem) interface{} {
+			interval := elem.(*testInterval)
+			return interval.end - interval.start
+		},
+	}
+
+	tree, err := New(WithDegree(4), WithDuplicates(DUPLICATE_ALLOW), WithIntervals(), WithAggregate(spanMonoid))
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	// spans of 0 to 9 repeated
+	for i := 0; i < 200; i++ {
+		tree.Insert(&testInterval{i, i + i%10})
+	}
+
+	agg, err := tree.Aggregate(nil, nil)
+	if err != nil || agg != 900 {
+		t.Errorf("sum of spans must be 900, but %v: %v", agg, err)
+		t.FailNow()
+	}
+
+	elems, _ := tree.Stabbing(testKey(105))
+	if len(elems) != 5 {
+		t.Errorf("5 intervals must contain 105, but %d", len(elems))
+		t.FailNow()
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+
+	// max end is verified along with the aggregate
+	root := tree.root
+	aggs := append([]interface{}{}, root.aggs...)
+	aggs[len(aggs)-1] = testKey(0)
+	root.aggs = aggs
+
+	err = tree.Verify()
+	if err == nil {
+		t.Errorf("broken max end must be detected")
+		t.FailNow()
+	}
+}
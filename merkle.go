@@ -0,0 +1,112 @@
+package bptree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+var (
+	// errors
+	ERR_NOT_HASHED = errors.New("tree is not hashed")
+)
+
+// digest is a 256 bits number in little endian words. Hash of elements is
+// the sum of SHA-256 of each encoded element modulo 2^256, so it depends on
+// elements only, not on the shape of the tree holding them.
+type digest [4]uint64
+
+func (d *digest) add(other digest) {
+	var carry uint64
+
+	for i := range d {
+		d[i], carry = bits.Add64(d[i], other[i], carry)
+	}
+}
+
+// bytes returns the digest in big endian
+func (d digest) bytes() []byte {
+	b := make([]byte, 32)
+
+	for i, word := range d {
+		binary.BigEndian.PutUint64(b[24-8*i:], word)
+	}
+
+	return b
+}
+
+func hashElem(codec Codec, elem Elem) (d digest, err error) {
+	data, err := codec.EncodeElem(unwrapElem(elem))
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+
+	for i := range d {
+		d[i] = binary.BigEndian.Uint64(sum[24-8*i:])
+	}
+
+	return
+}
+
+// WithMerkle makes every node keep hash of elements in its sub-tree, encoded
+// by the codec given by WithCodec. Hashes are updated along paths of
+// mutations and enable RootHash and RangeHash.
+func WithMerkle() Option {
+	return func(c *config) {
+		c.hashing = true
+	}
+}
+
+// rehash sums up hash of node out of its children
+func (tree *Bptree) rehash(node *indexNode) {
+	var sum digest
+
+	for _, child := range node.children {
+		switch c := child.(type) {
+		case *indexNode:
+			sum.add(c.hash)
+		case *entry:
+			sum.add(c.digest)
+		}
+	}
+
+	node.hash = sum
+}
+
+// RootHash returns hash of all elements stored in the tree, including
+// expired ones not removed yet. Trees of the same elements have the same
+// hash regardless of their shapes.
+func (tree *Bptree) RootHash() ([]byte, error) {
+	return tree.RangeHash(nil, nil)
+}
+
+// RangeHash returns hash of elements having keys in [from, to], nil for
+// unbounded. Comparing hashes of halves of a range, two trees find ranges
+// they differ in without exchanging elements.
+func (tree *Bptree) RangeHash(from, to Key) (hash []byte, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	defer tree.recovered("range hash", &err)
+
+	if !tree.hashing {
+		return nil, ERR_NOT_HASHED
+	}
+
+	var sum digest
+
+	tree.visitRange(from, to, func(node *indexNode) {
+		sum.add(node.hash)
+	}, func(stored Elem) {
+		sum.add(stored.(*entry).digest)
+	})
+
+	return sum.bytes(), nil
+}
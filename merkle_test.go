@@ -0,0 +1,223 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func newHashedTree(t *testing.T, maxDegree int, allowOverlap bool) *Bptree {
+	duplicates := DUPLICATE_REJECT
+	if allowOverlap {
+		duplicates = DUPLICATE_ALLOW
+	}
+
+	tree, err := New(WithDegree(maxDegree), WithDuplicates(duplicates), WithCodec(testCodec{}), WithMerkle())
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	return tree
+}
+
+func TestModelHashed(t *testing.T) {
+	for _, maxDegree := range []int{3, 4, 7} {
+		for _, allowOverlap := range []bool{false, true} {
+			r := rand.New(rand.NewSource(int64(maxDegree)))
+
+			ops := make([]modelOp, 1000)
+			for i := range ops {
+				ops[i] = randomModelOp(r, 200)
+			}
+
+			err := runModelTree(newHashedTree(t, maxDegree, allowOverlap), ops)
+			if err == nil {
+				continue
+			}
+
+			ops = shrinkModelOps(ops, func(ops []modelOp) bool {
+				return runModelTree(newHashedTree(t, maxDegree, allowOverlap), ops) != nil
+			})
+
+			t.Errorf("degree %d, overlap %v: %v\nminimal reproduction:\n%s",
+				maxDegree, allowOverlap, runModelTree(newHashedTree(t, maxDegree, allowOverlap), ops), formatModelOps(ops))
+			t.FailNow()
+		}
+	}
+}
+
+func TestRootHash(t *testing.T) {
+	a := newHashedTree(t, 4, false)
+	b := newHashedTree(t, 9, false)
+
+	empty, err := a.RootHash()
+	if err != nil {
+		t.Errorf("while hashing: %v", err)
+		t.FailNow()
+	}
+
+	// same elements in different order and shape
+	for i := 0; i < 300; i++ {
+		a.Insert(&testElem{i})
+	}
+
+	elems := make(Elems, 300)
+	for i := range elems {
+		elems[i] = &testElem{i}
+	}
+
+	b.BulkLoad(elems)
+
+	hashA, _ := a.RootHash()
+	hashB, _ := b.RootHash()
+
+	if !bytes.Equal(hashA, hashB) {
+		t.Errorf("trees of the same elements must have the same hash")
+		t.FailNow()
+	}
+
+	if bytes.Equal(hashA, empty) {
+		t.Errorf("hash must change by elements")
+		t.FailNow()
+	}
+
+	b.Remove(testKey(150))
+
+	hashB, _ = b.RootHash()
+	if bytes.Equal(hashA, hashB) {
+		t.Errorf("hash must change by removal")
+		t.FailNow()
+	}
+
+	b.Insert(&testElem{150})
+
+	hashB, _ = b.RootHash()
+	if !bytes.Equal(hashA, hashB) {
+		t.Errorf("hash must be restored by insertion")
+		t.FailNow()
+	}
+
+	plain, _ := NewBptree(4, _maxDepth, false)
+
+	_, err = plain.RootHash()
+	if !errors.Is(err, ERR_NOT_HASHED) {
+		t.Errorf("tree not hashed must fail with ERR_NOT_HASHED, but %v", err)
+		t.FailNow()
+	}
+
+	_, err = New(WithMerkle())
+	if err == nil {
+		t.Errorf("hashing without codec must fail")
+		t.FailNow()
+	}
+}
+
+func TestRangeHash(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	tree := newHashedTree(t, 4, true)
+
+	var keys []int
+	for i := 0; i < 500; i++ {
+		key := r.Intn(300)
+		keys = append(keys, key)
+		tree.Insert(&testElem{key})
+	}
+
+	for round := 0; round < 200; round++ {
+		from, to := r.Intn(320)-10, r.Intn(320)-10
+
+		var fromKey, toKey Key = testKey(from), testKey(to)
+
+		switch round % 4 {
+		case 1:
+			fromKey = nil
+		case 2:
+			toKey = nil
+		}
+
+		// hash of a tree of the range only
+		ranged := newHashedTree(t, 5, true)
+		for _, key := range keys {
+			if (fromKey == nil || key >= from) && (toKey == nil || key <= to) {
+				ranged.Insert(&testElem{key})
+			}
+		}
+
+		got, err := tree.RangeHash(fromKey, toKey)
+		if err != nil {
+			t.Errorf("while hashing range: %v", err)
+			t.FailNow()
+		}
+
+		want, _ := ranged.RootHash()
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("hash of [%v, %v] differs from a tree of the range", fromKey, toKey)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRangeHashBisect(t *testing.T) {
+	a := newHashedTree(t, 4, false)
+	b := newHashedTree(t, 6, false)
+
+	for i := 0; i < 1000; i++ {
+		a.Insert(&testElem{i})
+
+		if i != 777 {
+			b.Insert(&testElem{i})
+		}
+	}
+
+	// narrow down the range two trees differ in
+	lo, hi := 0, 999
+
+	for lo < hi {
+		mid := (lo + hi) / 2
+
+		hashA, _ := a.RangeHash(testKey(lo), testKey(mid))
+		hashB, _ := b.RangeHash(testKey(lo), testKey(mid))
+
+		if bytes.Equal(hashA, hashB) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	if lo != 777 {
+		t.Errorf("trees differ in %d, but found %d", 777, lo)
+		t.FailNow()
+	}
+}
+
+func TestVerifyDetectsChangedElem(t *testing.T) {
+	tree := newHashedTree(t, 4, false)
+
+	last := &testElem{99}
+
+	for i := 0; i < 99; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	tree.Insert(last)
+
+	err := tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+
+	// changed behind the tree, keeping order
+	last.val = 100
+
+	err = tree.Verify()
+	if !errors.Is(err, ERR_INVARIANT_VIOLATED) {
+		t.Errorf("changed element must be detected, but %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,263 @@
+package bptree
+
+import (
+	"errors"
+	"time"
+)
+
+// Min returns the element of the smallest key, ERR_EMPTY if no element is
+// alive.
+func (tree *Bptree) Min() (elem Elem, err error) {
+	return tree.edgeElem(OP_MIN, ToLeft)
+}
+
+// Max returns the element of the largest key, ERR_EMPTY if no element is
+// alive.
+func (tree *Bptree) Max() (elem Elem, err error) {
+	return tree.edgeElem(OP_MAX, ToRight)
+}
+
+// PopMin removes and returns the element of the smallest key, ERR_EMPTY if
+// no element is alive. Hooks run like Remove.
+func (tree *Bptree) PopMin() (elem Elem, err error) {
+	elems, err := tree.pop(OP_POP_MIN, ToLeft, 1)
+	if len(elems) > 0 {
+		elem = elems[0]
+	}
+
+	return
+}
+
+// PopMax removes and returns the element of the largest key, ERR_EMPTY if
+// no element is alive. Hooks run like Remove.
+func (tree *Bptree) PopMax() (elem Elem, err error) {
+	elems, err := tree.pop(OP_POP_MAX, ToRight, 1)
+	if len(elems) > 0 {
+		elem = elems[0]
+	}
+
+	return
+}
+
+// PopMinN removes and returns up to n elements of the smallest keys in
+// order. It returns less without error once the tree runs out of elements,
+// and nothing for zero n.
+func (tree *Bptree) PopMinN(n int) (elems Elems, err error) {
+	elems, err = tree.pop(OP_POP_MIN, ToLeft, n)
+	if err == ERR_EMPTY {
+		err = nil
+	}
+
+	return
+}
+
+// edgeElem returns the first alive element from the leaf at the edge of
+// direction
+func (tree *Bptree) edgeElem(op string, direction Direction) (elem Elem, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	if tree.observer != nil {
+		defer tree.observe(op, nil, time.Now(), &err)
+	}
+
+	defer tree.recovered(op, &err)
+
+	if tree.head == nil {
+		return nil, ERR_EMPTY
+	}
+
+	now := tree.now()
+
+	// start from outside of the edge, so the first move lands on it
+	node, i := tree.head, -1
+	if direction == ToRight {
+		node, i = tree.tail, len(tree.tail.children)
+	}
+
+	for {
+		switch direction {
+		case ToRight:
+			node, i = prevPos(node, i)
+		case ToLeft:
+			node, i = nextPos(node, i)
+		}
+
+		if node == nil {
+			return nil, ERR_EMPTY
+		}
+
+		var ok bool
+
+		elem, ok = tree.visible(node.children[i], now)
+		if ok {
+			tree.touch(node.children[i])
+			return elem, nil
+		}
+	}
+}
+
+// pop removes up to n elements from the edge of direction. Elements are
+// decided first, running Before hooks, and then cut off at once so the edge
+// is rebalanced once. After hooks run for them in order, and a failing one
+// puts back its element and the rest.
+func (tree *Bptree) pop(op string, direction Direction, n int) (elems Elems, err error) {
+	if !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	if n < 0 {
+		return nil, errors.New("number of elements to pop must to have zero or a positive value")
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.observer != nil {
+		defer tree.observe(op, nil, time.Now(), &err)
+	}
+
+	defer tree.mutated(op, &err)
+
+	err = tree.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.head == nil {
+		return nil, ERR_EMPTY
+	}
+
+	now := tree.now()
+
+	// stored elements to pop, and expired ones removed on the way
+	var popped, expired Elems
+
+	// start from outside of the edge, so the first move lands on it
+	node, i := tree.head, -1
+	if direction == ToRight {
+		node, i = tree.tail, len(tree.tail.children)
+	}
+
+	for {
+		switch direction {
+		case ToRight:
+			node, i = prevPos(node, i)
+		case ToLeft:
+			node, i = nextPos(node, i)
+		}
+
+		if node == nil {
+			if len(popped) < n {
+				err = ERR_EMPTY
+			}
+
+			break
+		}
+
+		stored := node.children[i]
+
+		elem, ok := tree.visible(stored, now)
+		if !ok {
+			expired = append(expired, stored)
+			continue
+		}
+
+		if len(popped) == n {
+			break
+		}
+
+		err = tree.hooks.run(tree.hooks.beforeRemove, elem)
+		if err != nil {
+			break
+		}
+
+		popped = append(popped, stored)
+	}
+
+	if len(popped)+len(expired) > 0 {
+		tree.cutEdge(direction, node, i)
+
+		for _, stored := range popped {
+			tree.account(stored, -1)
+		}
+
+		for _, stored := range expired {
+			tree.account(stored, -1)
+		}
+
+		for j, stored := range expired {
+			expired[j] = unwrapElem(stored)
+		}
+
+		tree.notifyExpired(expired)
+	}
+
+	for j, stored := range popped {
+		elem := unwrapElem(stored)
+
+		hookErr := tree.hooks.run(tree.hooks.afterRemove, elem)
+		if hookErr != nil {
+			// roll back, elements are lost if they can't be put back
+			for _, stored := range popped[j:] {
+				e := tree.insert(stored)
+				if e != nil {
+					tree.corrupted(nil, "popped element %v can't be put back: %v", stored.Key(), e)
+				}
+			}
+
+			return elems, hookErr
+		}
+
+		elems = append(elems, elem)
+	}
+
+	return elems, err
+}
+
+// cutEdge removes every element on the edge of direction up to the i-th
+// element of node, which stays, rebalancing the edge once. nil node removes
+// every element. Caller accounts removed elements.
+func (tree *Bptree) cutEdge(direction Direction, node *indexNode, i int) {
+	if node == nil {
+		tree.root, tree.head, tree.tail = nil, nil, nil
+		return
+	}
+
+	paths, err := tree.pathTo(node)
+	if err != nil {
+		tree.corrupted(nil, "leaf to cut is not found: %v", err)
+		return
+	}
+
+	var pieces []*indexNode
+
+	if direction == ToLeft {
+		_, rights := tree.cut(paths, i)
+
+		// from leaf up in order of keys
+		for k := len(rights) - 1; k >= 0; k-- {
+			pieces = append(pieces, rights[k])
+		}
+	} else {
+		pieces, _ = tree.cut(paths, i+1)
+	}
+
+	tree.root, tree.head, tree.tail = nil, nil, nil
+
+	for _, piece := range pieces {
+		tree.join(piece)
+	}
+
+	tree.settle()
+}
@@ -0,0 +1,336 @@
+package bptree
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMinMax(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	_, err = tree.Min()
+	if !errors.Is(err, ERR_EMPTY) {
+		t.Errorf("min of empty tree must be ERR_EMPTY, but %v", err)
+		t.FailNow()
+	}
+
+	_, err = tree.PopMax()
+	if !errors.Is(err, ERR_EMPTY) {
+		t.Errorf("pop of empty tree must be ERR_EMPTY, but %v", err)
+		t.FailNow()
+	}
+
+	for _, i := range []int{50, 10, 90, 30, 70} {
+		tree.Insert(&testElem{i})
+	}
+
+	min, err := tree.Min()
+	if err != nil || min.Key() != testKey(10) {
+		t.Errorf("min must be 10, but %v: %v", min, err)
+		t.FailNow()
+	}
+
+	max, err := tree.Max()
+	if err != nil || max.Key() != testKey(90) {
+		t.Errorf("max must be 90, but %v: %v", max, err)
+		t.FailNow()
+	}
+
+	elem, err := tree.PopMin()
+	if err != nil || elem.Key() != testKey(10) {
+		t.Errorf("popped min must be 10, but %v: %v", elem, err)
+		t.FailNow()
+	}
+
+	elem, err = tree.PopMax()
+	if err != nil || elem.Key() != testKey(90) {
+		t.Errorf("popped max must be 90, but %v: %v", elem, err)
+		t.FailNow()
+	}
+
+	if tree.Len() != 3 {
+		t.Errorf("3 elements must remain, but %d", tree.Len())
+		t.FailNow()
+	}
+}
+
+func TestPopMinN(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	tree.SetVerifyOnMutation(true)
+
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	for next := 0; next < 1000; {
+		elems, err := tree.PopMinN(7)
+		if err != nil {
+			t.Errorf("while popping from %d: %v", next, err)
+			t.FailNow()
+		}
+
+		for _, elem := range elems {
+			if elem.Key() != testKey(next) {
+				t.Errorf("popped %v, expected %d", elem, next)
+				t.FailNow()
+			}
+
+			next += 1
+		}
+
+		if len(elems) != 7 && next != 1000 {
+			t.Errorf("popped %d elements before running out", len(elems))
+			t.FailNow()
+		}
+	}
+
+	elems, err := tree.PopMinN(7)
+	if err != nil || len(elems) != 0 {
+		t.Errorf("empty tree must pop nothing without error, but %v: %v", elems, err)
+		t.FailNow()
+	}
+}
+
+func TestPopMinExpired(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	var expired Elems
+	tree.OnExpire(func(elems Elems) {
+		expired = append(expired, elems...)
+	})
+
+	// the smallest ones expire
+	for i := 0; i < 20; i++ {
+		if i < 10 {
+			err = tree.InsertWithTTL(&testElem{i}, time.Minute)
+		} else {
+			err = tree.Insert(&testElem{i})
+		}
+
+		if err != nil {
+			t.Errorf("while inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	clock.Advance(time.Minute)
+
+	min, err := tree.Min()
+	if err != nil || min.Key() != testKey(10) {
+		t.Errorf("min must skip expired elements, but %v: %v", min, err)
+		t.FailNow()
+	}
+
+	elem, err := tree.PopMin()
+	if err != nil || elem.Key() != testKey(10) {
+		t.Errorf("popped min must be 10, but %v: %v", elem, err)
+		t.FailNow()
+	}
+
+	if len(expired) != 10 || tree.Len() != 9 {
+		t.Errorf("expired elements on the edge must be removed, but %d expired and %d remain", len(expired), tree.Len())
+		t.FailNow()
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestPopMaxHookRollback(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	veto := errors.New("veto")
+	tree.AfterRemove(func(elem Elem) error {
+		return veto
+	})
+
+	_, err = tree.PopMax()
+	if err != veto {
+		t.Errorf("hook error must be returned, but %v", err)
+		t.FailNow()
+	}
+
+	max, err := tree.Max()
+	if err != nil || max.Key() != testKey(9) {
+		t.Errorf("popped element must be rolled back, but max %v: %v", max, err)
+		t.FailNow()
+	}
+}
+
+func TestPopMinNRebalancesOnce(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	before := tree.Stats()
+
+	elems, err := tree.PopMinN(600)
+	if err != nil || len(elems) != 600 {
+		t.Errorf("600 elements must be popped, but %d: %v", len(elems), err)
+		t.FailNow()
+	}
+
+	// edge is cut at once rather than merged leaf by leaf
+	after := tree.Stats()
+	if after.Merges != before.Merges || after.Redistributions != before.Redistributions {
+		t.Errorf("popping must not merge nor redistribute nodes one by one")
+		t.FailNow()
+	}
+
+	min, _ := tree.Min()
+	if min.Key() != testKey(600) || tree.Len() != 400 {
+		t.Errorf("min must be 600 of 400 elements, but %v of %d", min, tree.Len())
+		t.FailNow()
+	}
+
+	err = tree.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestPopRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 200; round++ {
+		tree, err := New(WithDegree(3+r.Intn(5)), WithDuplicates(DUPLICATE_ALLOW),
+			WithCodec(testCodec{}), WithMerkle(), WithAggregate(sumMonoid))
+		if err != nil {
+			t.Errorf("while creating bptree: %v", err)
+			t.FailNow()
+		}
+
+		clock := &testClock{now: time.Unix(0, 0)}
+		tree.SetClock(clock)
+
+		var alive []int
+
+		size := r.Intn(300)
+		for i := 0; i < size; i++ {
+			key := r.Intn(100)
+
+			if r.Intn(4) == 0 {
+				tree.InsertWithTTL(&testElem{key}, time.Minute)
+			} else {
+				tree.Insert(&testElem{key})
+				alive = append(alive, key)
+			}
+		}
+
+		clock.Advance(time.Hour)
+		sort.Ints(alive)
+
+		n := r.Intn(len(alive) + 2)
+
+		var elems Elems
+
+		if r.Intn(2) == 0 {
+			elems, err = tree.PopMinN(n)
+			if len(alive) > n {
+				alive = alive[n:]
+			} else {
+				alive = nil
+			}
+		} else {
+			for i := 0; i < n && err == nil; i++ {
+				var elem Elem
+
+				elem, err = tree.PopMax()
+				if err == nil {
+					elems = append(elems, elem)
+					alive = alive[:len(alive)-1]
+				}
+			}
+
+			if err == ERR_EMPTY {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			t.Errorf("round %d: while popping: %v", round, err)
+			t.FailNow()
+		}
+
+		err = tree.Verify()
+		if err != nil {
+			t.Errorf("round %d: invalid tree: %v", round, err)
+			t.FailNow()
+		}
+
+		if keys := treeKeys(tree); !equalInts(keys, alive) {
+			t.Errorf("round %d: %v remain, expected %v", round, keys, alive)
+			t.FailNow()
+		}
+	}
+}
+
+func TestPopMinNCount(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	observer := &testObserver{ops: make(map[string]int), events: make(map[StructuralChange]int)}
+	tree.SetObserver(observer)
+
+	elems, err := tree.PopMinN(0)
+	if err != nil || len(elems) != 0 {
+		t.Errorf("popping zero must pop nothing, but %v: %v", elems, err)
+		t.FailNow()
+	}
+
+	elems, err = tree.PopMinN(-1)
+	if err == nil || len(elems) != 0 {
+		t.Errorf("popping negative must fail, but %v: %v", elems, err)
+		t.FailNow()
+	}
+
+	// neither touches the tree
+	if tree.Len() != 10 || observer.ops["pop min"] != 0 {
+		t.Errorf("tree must be kept, but %d elements after %d pops", tree.Len(), observer.ops["pop min"])
+		t.FailNow()
+	}
+}
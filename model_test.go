@@ -0,0 +1,540 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type modelOpKind int
+
+const (
+	MODEL_INSERT modelOpKind = iota
+	MODEL_REMOVE
+	MODEL_SEARCH
+	MODEL_SEARCH_NEARBY
+	MODEL_ELEM_AT
+	MODEL_ELEM_RANGE
+	MODEL_ELEM_RANGE_TO
+	MODEL_POP // PopMin to left, PopMax to right
+
+	numModelOpKinds
+)
+
+// modelOp is an operation applied to both tree and model. Operations reading
+// around a position start from the result of SearchNearby(key, direction).
+type modelOp struct {
+	kind      modelOpKind
+	key       int
+	direction Direction
+	offset    int // ElemAt, ElemRange
+	to        int // ElemRangeTo
+	maxN      int // ElemRangeTo
+}
+
+func (op modelOp) String() string {
+	dir := "ToRight"
+	if op.direction == ToLeft {
+		dir = "ToLeft"
+	}
+
+	switch op.kind {
+	case MODEL_INSERT:
+		return fmt.Sprintf("Insert(%d)", op.key)
+	case MODEL_REMOVE:
+		return fmt.Sprintf("Remove(%d)", op.key)
+	case MODEL_SEARCH:
+		return fmt.Sprintf("Search(%d)", op.key)
+	case MODEL_SEARCH_NEARBY:
+		return fmt.Sprintf("SearchNearby(%d, %s)", op.key, dir)
+	case MODEL_ELEM_AT:
+		return fmt.Sprintf("SearchNearby(%d, %s).ElemAt(%d)", op.key, dir, op.offset)
+	case MODEL_ELEM_RANGE:
+		return fmt.Sprintf("SearchNearby(%d, %s).ElemRange(%d)", op.key, dir, op.offset)
+	case MODEL_POP:
+		if op.direction == ToLeft {
+			return "PopMin()"
+		}
+		return "PopMax()"
+	default:
+		return fmt.Sprintf("SearchNearby(%d, %s).ElemRangeTo(%d, %s, %d)", op.key, dir, op.to, dir, op.maxN)
+	}
+}
+
+func randomModelOp(r *rand.Rand, keySpace int) modelOp {
+	op := modelOp{
+		kind:      modelOpKind(r.Intn(int(numModelOpKinds))),
+		key:       r.Intn(keySpace),
+		direction: ToRight,
+		offset:    r.Intn(11) - 5,
+		to:        r.Intn(keySpace),
+		maxN:      r.Intn(10),
+	}
+
+	// offsets beyond any tree, meaning no limit
+	if r.Intn(10) == 0 {
+		huge := []int{math.MaxInt, math.MinInt, math.MaxUint32, -math.MaxUint32}
+		op.offset = huge[r.Intn(len(huge))]
+		op.maxN = math.MaxInt
+	}
+
+	// writes are weighted to grow and shrink the tree
+	if r.Intn(2) == 0 {
+		op.kind = MODEL_INSERT
+		if r.Intn(3) == 0 {
+			op.kind = MODEL_REMOVE
+		}
+	}
+
+	if r.Intn(2) == 0 {
+		op.direction = ToLeft
+	}
+
+	return op
+}
+
+// model is the reference of a tree, keys of elements in order
+type model struct {
+	keys         []int
+	allowOverlap bool
+}
+
+func (m *model) find(key int) (int, bool) {
+	i := sort.SearchInts(m.keys, key)
+	return i, i < len(m.keys) && m.keys[i] == key
+}
+
+func (m *model) insert(key int) error {
+	i, found := m.find(key)
+	if found && !m.allowOverlap {
+		return ERR_OVERLAPPED
+	}
+
+	m.keys = append(m.keys, 0)
+	copy(m.keys[i+1:], m.keys[i:])
+	m.keys[i] = key
+
+	return nil
+}
+
+func (m *model) remove(key int) error {
+	i, found := m.find(key)
+	if !found {
+		return ERR_NOT_FOUND
+	}
+
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+
+	return nil
+}
+
+// nearby returns candidate positions of SearchNearby; equal keys make all of
+// their run candidates
+func (m *model) nearby(key int, direction Direction) (positions []int, equal bool, err error) {
+	i, found := m.find(key)
+
+	switch {
+	case found:
+		equal = true
+	case direction == ToRight && i < len(m.keys):
+	case direction == ToLeft && i > 0:
+		i -= 1
+	case direction == ToRight:
+		return nil, false, ERR_SEARCH_OVERFLOWED
+	default:
+		return nil, false, ERR_SEARCH_UNDERFLOWED
+	}
+
+	for j := sort.SearchInts(m.keys, m.keys[i]); j < len(m.keys) && m.keys[j] == m.keys[i]; j++ {
+		positions = append(positions, j)
+	}
+
+	return
+}
+
+func (m *model) elemRange(p, offset int) []int {
+	offset = m.clamp(offset)
+
+	lo, hi := p, p+offset
+	if offset < 0 {
+		lo, hi = p+offset, p
+	}
+
+	if lo < 0 {
+		lo = 0
+	}
+
+	if hi > len(m.keys)-1 {
+		hi = len(m.keys) - 1
+	}
+
+	return m.keys[lo : hi+1]
+}
+
+// clamp limits offset to the number of keys, so it doesn't overflow
+func (m *model) clamp(offset int) int {
+	return max(-len(m.keys), min(offset, len(m.keys)))
+}
+
+func (m *model) elemRangeTo(p, to int, direction Direction, maxN int) []int {
+	lo, hi := p, p
+
+	for n := 1; n < maxN; n++ {
+		if direction == ToRight {
+			if hi+1 >= len(m.keys) || m.keys[hi+1] > to {
+				break
+			}
+			hi += 1
+		} else {
+			if lo-1 < 0 || m.keys[lo-1] < to {
+				break
+			}
+			lo -= 1
+		}
+	}
+
+	return m.keys[lo : hi+1]
+}
+
+func elemKeys(elems Elems) []int {
+	keys := make([]int, len(elems))
+
+	for i, elem := range elems {
+		keys[i] = int(elem.Key().(testKey))
+	}
+
+	return keys
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameError tells err of tree is what model expects. Empty tree may tell it
+// is empty rather than not found or out of range.
+func sameError(err, expected error, empty bool) bool {
+	if empty && errors.Is(err, ERR_EMPTY) {
+		return true
+	}
+
+	if expected == nil {
+		return err == nil
+	}
+
+	return errors.Is(err, expected)
+}
+
+// runModel applies ops to a new tree and model, and returns the first
+// mismatch or violated invariant
+func runModel(maxDegree int, allowOverlap bool, ops []modelOp) (err error) {
+	tree, err := NewBptree(maxDegree, _maxDepth, allowOverlap)
+	if err != nil {
+		return err
+	}
+
+	return runModelTree(tree, ops)
+}
+
+// runModelTree is runModel with a tree made by caller
+func runModelTree(tree *Bptree, ops []modelOp) (err error) {
+	m := &model{allowOverlap: tree.allowOverlap}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	for i, op := range ops {
+		err = checkModelOp(tree, m, op)
+		if err == nil {
+			err = tree.Verify()
+		}
+
+		if err != nil {
+			return fmt.Errorf("op %d %v: %v", i, op, err)
+		}
+	}
+
+	return nil
+}
+
+func checkModelOp(tree *Bptree, m *model, op modelOp) error {
+	key := testKey(op.key)
+	empty := len(m.keys) == 0
+
+	switch op.kind {
+	case MODEL_INSERT:
+		err := tree.Insert(&testElem{op.key})
+		expected := m.insert(op.key)
+
+		if !sameError(err, expected, false) {
+			return fmt.Errorf("returned %v, expected %v", err, expected)
+		}
+
+		return nil
+
+	case MODEL_REMOVE:
+		err := tree.Remove(key)
+		expected := m.remove(op.key)
+
+		if !sameError(err, expected, empty) {
+			return fmt.Errorf("returned %v, expected %v", err, expected)
+		}
+
+		return nil
+
+	case MODEL_SEARCH:
+		elem, ok, err := tree.SearchElem(key)
+		_, found := m.find(op.key)
+
+		if err != nil && !(empty && errors.Is(err, ERR_EMPTY)) {
+			return fmt.Errorf("returned %v", err)
+		}
+
+		if ok != found || (ok && elem.Key() != key) {
+			return fmt.Errorf("found %v(%v), expected %v", elem, ok, found)
+		}
+
+		return nil
+
+	case MODEL_POP:
+		min, minErr := tree.Min()
+		max, maxErr := tree.Max()
+
+		pop := tree.PopMax
+		if op.direction == ToLeft {
+			pop = tree.PopMin
+		}
+
+		elem, err := pop()
+
+		if empty {
+			if !errors.Is(minErr, ERR_EMPTY) || !errors.Is(maxErr, ERR_EMPTY) || !errors.Is(err, ERR_EMPTY) {
+				return fmt.Errorf("returned %v, %v and %v on empty tree", minErr, maxErr, err)
+			}
+
+			return nil
+		}
+
+		if minErr != nil || maxErr != nil || err != nil {
+			return fmt.Errorf("returned %v, %v and %v", minErr, maxErr, err)
+		}
+
+		if min.Key() != testKey(m.keys[0]) || max.Key() != testKey(m.keys[len(m.keys)-1]) {
+			return fmt.Errorf("min %v and max %v, expected %d and %d", min, max, m.keys[0], m.keys[len(m.keys)-1])
+		}
+
+		i := len(m.keys) - 1
+		if op.direction == ToLeft {
+			i = 0
+		}
+
+		if elem.Key() != testKey(m.keys[i]) {
+			return fmt.Errorf("popped %v, expected %d", elem, m.keys[i])
+		}
+
+		m.keys = append(m.keys[:i], m.keys[i+1:]...)
+
+		return nil
+	}
+
+	res, equal, err := tree.SearchNearby(key, op.direction)
+	positions, expectedEqual, expected := m.nearby(op.key, op.direction)
+
+	if !sameError(err, expected, empty) {
+		return fmt.Errorf("nearby returned %v, expected %v", err, expected)
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	if equal != expectedEqual || int(res.Elem().Key().(testKey)) != m.keys[positions[0]] {
+		return fmt.Errorf("nearby found %v(equal %v), expected %d(equal %v)", res.Elem(), equal, m.keys[positions[0]], expectedEqual)
+	}
+
+	// with overlapped keys, result may be any of them
+	var got, want []int
+
+	for _, p := range positions {
+		switch op.kind {
+		case MODEL_ELEM_AT:
+			elem, ok := res.ElemAt(op.offset)
+			got = nil
+			if ok {
+				got = []int{int(elem.Key().(testKey))}
+			}
+
+			want = nil
+			if q := p + m.clamp(op.offset); q >= 0 && q < len(m.keys) {
+				want = []int{m.keys[q]}
+			}
+
+		case MODEL_ELEM_RANGE:
+			elems, n := res.ElemRange(op.offset)
+			if n != len(elems) {
+				return fmt.Errorf("range of %d elements tells %d", len(elems), n)
+			}
+
+			got = elemKeys(elems)
+			want = m.elemRange(p, op.offset)
+
+		case MODEL_ELEM_RANGE_TO:
+			elems, n := res.ElemRangeTo(testKey(op.to), op.direction, op.maxN)
+			if n != len(elems) {
+				return fmt.Errorf("range of %d elements tells %d", len(elems), n)
+			}
+
+			got = elemKeys(elems)
+			want = m.elemRangeTo(p, op.to, op.direction, op.maxN)
+		}
+
+		if equalInts(got, want) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("got %v, expected %v", got, want)
+}
+
+// shrinkModelOps minimizes failing ops by removing chunks of them, then
+// single ones, as long as the rest still fails
+func shrinkModelOps(ops []modelOp, fails func([]modelOp) bool) []modelOp {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i+chunk <= len(ops); {
+			candidate := append(append([]modelOp{}, ops[:i]...), ops[i+chunk:]...)
+
+			if fails(candidate) {
+				ops = candidate
+			} else {
+				i += chunk
+			}
+		}
+	}
+
+	return ops
+}
+
+func formatModelOps(ops []modelOp) string {
+	lines := make([]string, len(ops))
+
+	for i, op := range ops {
+		lines[i] = "\t" + op.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestModel(t *testing.T) {
+	const sequences = 20
+	const opsPerSequence = 300
+
+	for _, maxDegree := range []int{3, 4, 5, 6, 7, 8, 16, 32} {
+		for _, allowOverlap := range []bool{false, true} {
+			for seq := 0; seq < sequences; seq++ {
+				seed := int64(maxDegree*1000 + seq)
+				r := rand.New(rand.NewSource(seed))
+
+				// small key space for overlaps and emptying, large one for
+				// deep trees
+				keySpace := 20
+				if seq%2 == 1 {
+					keySpace = 500
+				}
+
+				ops := make([]modelOp, opsPerSequence)
+				for i := range ops {
+					ops[i] = randomModelOp(r, keySpace)
+				}
+
+				err := runModel(maxDegree, allowOverlap, ops)
+				if err == nil {
+					continue
+				}
+
+				ops = shrinkModelOps(ops, func(ops []modelOp) bool {
+					return runModel(maxDegree, allowOverlap, ops) != nil
+				})
+
+				t.Errorf("degree %d, overlap %v, seed %d: %v\nminimal reproduction:\n%s",
+					maxDegree, allowOverlap, seed, runModel(maxDegree, allowOverlap, ops), formatModelOps(ops))
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestModelSeparateDegrees(t *testing.T) {
+	for _, leafCapacity := range []int{2, 3, 8, 64} {
+		for _, internalDegree := range []int{3, 4, 16, 128} {
+			newTree := func() *Bptree {
+				tree, err := New(WithLeafCapacity(leafCapacity), WithInternalDegree(internalDegree), WithDuplicates(DUPLICATE_ALLOW))
+				if err != nil {
+					t.Errorf("while creating bptree: %v", err)
+					t.FailNow()
+				}
+
+				return tree
+			}
+
+			r := rand.New(rand.NewSource(int64(leafCapacity*1000 + internalDegree)))
+
+			ops := make([]modelOp, 1000)
+			for i := range ops {
+				ops[i] = randomModelOp(r, 500)
+			}
+
+			err := runModelTree(newTree(), ops)
+			if err == nil {
+				continue
+			}
+
+			ops = shrinkModelOps(ops, func(ops []modelOp) bool {
+				return runModelTree(newTree(), ops) != nil
+			})
+
+			t.Errorf("leaf %d, internal %d: %v\nminimal reproduction:\n%s",
+				leafCapacity, internalDegree, runModelTree(newTree(), ops), formatModelOps(ops))
+			t.FailNow()
+		}
+	}
+}
+
+func TestModelShrink(t *testing.T) {
+	ops := make([]modelOp, 100)
+	for i := range ops {
+		ops[i] = modelOp{kind: MODEL_INSERT, key: i}
+	}
+
+	// fails whenever both 13 and 42 are inserted
+	fails := func(ops []modelOp) bool {
+		var found13, found42 bool
+
+		for _, op := range ops {
+			found13 = found13 || op.key == 13
+			found42 = found42 || op.key == 42
+		}
+
+		return found13 && found42
+	}
+
+	ops = shrinkModelOps(ops, fails)
+	if len(ops) != 2 || ops[0].key != 13 || ops[1].key != 42 {
+		t.Errorf("ops must be shrunk to two, but\n%s", formatModelOps(ops))
+	}
+}
@@ -0,0 +1,126 @@
+package bptree
+
+import (
+	"errors"
+	"expvar"
+	"time"
+)
+
+type StructuralChange int
+
+const (
+	STRUCTURE_SPLIT StructuralChange = iota
+	STRUCTURE_MERGE
+	STRUCTURE_REDISTRIBUTION
+	STRUCTURE_ROOT_GROWN
+	STRUCTURE_ROOT_SHRUNK
+)
+
+func (change StructuralChange) String() string {
+	switch change {
+	case STRUCTURE_SPLIT:
+		return "split"
+	case STRUCTURE_MERGE:
+		return "merge"
+	case STRUCTURE_REDISTRIBUTION:
+		return "redistribution"
+	case STRUCTURE_ROOT_GROWN:
+		return "root grown"
+	case STRUCTURE_ROOT_SHRUNK:
+		return "root shrunk"
+	default:
+		return "unknown"
+	}
+}
+
+// operations reported to ObserveOp
+const (
+	OP_INSERT        = "insert"
+	OP_REMOVE        = "remove"
+	OP_SEARCH        = "search"
+	OP_SEARCH_NEARBY = "search nearby"
+	OP_MIN           = "min"
+	OP_MAX           = "max"
+	OP_POP_MIN       = "pop min" // by PopMin and PopMinN
+	OP_POP_MAX       = "pop max"
+	OP_OVERLAPPING   = "overlapping" // by Overlapping and Stabbing
+	OP_EXPIRE        = "expire"
+	OP_CLEAR         = "clear"
+	OP_SPLIT         = "split"
+)
+
+// StructuralEvent tells a change of nodes. Depth is of the changed node from
+// root, or height of the tree after the root has grown or shrunk.
+type StructuralEvent struct {
+	Change StructuralChange
+	Depth  int
+}
+
+// Observer is notified of operations and structural changes of a tree. It is
+// called while the tree is locked, so it must be quick and must not call the
+// tree back. Searches may call it concurrently.
+type Observer interface {
+	// ObserveOp is called after op, one of OP_ constants, on key, with nil
+	// err on success. Key is nil for ops not on a key. Search missing the
+	// key reports ERR_NOT_FOUND.
+	ObserveOp(op string, key Key, duration time.Duration, err error)
+
+	ObserveEvent(event StructuralEvent)
+}
+
+// SetObserver registers observer, nil to unregister. Without observer no
+// time is measured.
+func (tree *Bptree) SetObserver(observer Observer) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.observer = observer
+}
+
+// observe reports op started at start, caller must hold tree lock and check
+// observer is registered
+func (tree *Bptree) observe(op string, key Key, start time.Time, errp *error) {
+	tree.observer.ObserveOp(op, key, time.Since(start), *errp)
+}
+
+// changed reports a structural change, caller must hold tree lock
+func (tree *Bptree) changed(change StructuralChange, depth int) {
+	if tree.observer == nil {
+		return
+	}
+
+	tree.observer.ObserveEvent(StructuralEvent{Change: change, Depth: depth})
+}
+
+// ExpvarObserver publishes counters of operations and structural changes as
+// an expvar.Map, e.g. "insert", "insert.errors", "insert.nanoseconds" and
+// "split". Not found keys are counted as misses like "search.misses" rather
+// than errors.
+type ExpvarObserver struct {
+	vars *expvar.Map
+}
+
+// NewExpvarObserver publishes the counters under name, which must be unique
+// in the process like any expvar.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	return &ExpvarObserver{
+		vars: expvar.NewMap(name),
+	}
+}
+
+func (o *ExpvarObserver) ObserveOp(op string, key Key, duration time.Duration, err error) {
+	o.vars.Add(op, 1)
+	o.vars.Add(op+".nanoseconds", int64(duration))
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ERR_NOT_FOUND):
+		o.vars.Add(op+".misses", 1)
+	default:
+		o.vars.Add(op+".errors", 1)
+	}
+}
+
+func (o *ExpvarObserver) ObserveEvent(event StructuralEvent) {
+	o.vars.Add(event.Change.String(), 1)
+}
@@ -0,0 +1,164 @@
+package bptree
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testObserver struct {
+	ops    map[string]int
+	misses int
+	events map[StructuralChange]int
+
+	lock sync.Mutex
+}
+
+func (o *testObserver) ObserveOp(op string, key Key, duration time.Duration, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.ops[op] += 1
+
+	if err == ERR_NOT_FOUND {
+		o.misses += 1
+	}
+}
+
+func (o *testObserver) ObserveEvent(event StructuralEvent) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.events[event.Change] += 1
+}
+
+func TestObserver(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	o := &testObserver{
+		ops:    make(map[string]int),
+		events: make(map[StructuralChange]int),
+	}
+
+	tree.SetObserver(o)
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	for i := 0; i < 200; i += 2 {
+		tree.SearchElem(testKey(i))
+	}
+
+	tree.SearchElemNearby(testKey(50), ToLeft)
+
+	for i := 0; i < 100; i++ {
+		tree.Remove(testKey(i))
+	}
+
+	if o.ops["insert"] != 100 || o.ops["search"] != 100 || o.ops["search nearby"] != 1 || o.ops["remove"] != 100 {
+		t.Errorf("unexpected operations observed: %v", o.ops)
+	}
+
+	if o.misses != 50 {
+		t.Errorf("50 searches must miss, but %d", o.misses)
+	}
+
+	stats := tree.Stats()
+
+	if uint64(o.events[STRUCTURE_SPLIT]) != stats.Splits || uint64(o.events[STRUCTURE_MERGE]) != stats.Merges ||
+		uint64(o.events[STRUCTURE_REDISTRIBUTION]) != stats.Redistributions {
+		t.Errorf("observed events must match stats: %v, %+v", o.events, stats)
+	}
+
+	// emptied tree shrinks to a leaf
+	if o.events[STRUCTURE_ROOT_GROWN] == 0 || o.events[STRUCTURE_ROOT_GROWN] != o.events[STRUCTURE_ROOT_SHRUNK] {
+		t.Errorf("root must grow and shrink as many: %v", o.events)
+	}
+
+	// unregistered
+	tree.SetObserver(nil)
+	tree.Insert(&testElem{0})
+
+	if o.ops["insert"] != 100 {
+		t.Errorf("unregistered observer must not be called")
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	o := NewExpvarObserver("bptree_test_observer")
+	tree.SetObserver(o)
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	tree.Insert(&testElem{0})
+	tree.Remove(testKey(100))
+
+	expected := map[string]string{
+		"insert":        "11",
+		"insert.errors": "1",
+		"remove":        "1",
+		"remove.misses": "1",
+	}
+
+	for name, value := range expected {
+		v := o.vars.Get(name)
+		if v == nil || v.String() != value {
+			t.Errorf("%s must be %s, but %v", name, value, v)
+		}
+	}
+
+	if o.vars.Get("split") == nil {
+		t.Errorf("splits must be counted")
+	}
+}
+
+func TestObserverOps(t *testing.T) {
+	tree, err := New(WithDegree(4), WithDuplicates(DUPLICATE_ALLOW), WithIntervals())
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	o := &testObserver{ops: make(map[string]int), events: make(map[StructuralChange]int)}
+	tree.SetObserver(o)
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(&testInterval{i, i + 2})
+	}
+
+	tree.Remove(testKey(0))
+	tree.Search(testKey(1))
+	tree.SearchNearby(testKey(1), ToRight)
+	tree.Min()
+	tree.Max()
+	tree.PopMin()
+	tree.PopMax()
+	tree.Stabbing(testKey(5))
+	tree.ExpireNow()
+	tree.SplitAt(testKey(5))
+	tree.Clear()
+
+	for _, op := range []string{OP_INSERT, OP_REMOVE, OP_SEARCH, OP_SEARCH_NEARBY, OP_MIN, OP_MAX,
+		OP_POP_MIN, OP_POP_MAX, OP_OVERLAPPING, OP_EXPIRE, OP_SPLIT, OP_CLEAR} {
+		if o.ops[op] == 0 {
+			t.Errorf("%q must be observed", op)
+		}
+	}
+
+	if len(o.ops) != 12 {
+		t.Errorf("only listed ops must be observed, but %v", o.ops)
+	}
+}
@@ -0,0 +1,177 @@
+package bptree
+
+import (
+	"errors"
+	"sync"
+)
+
+type DuplicatePolicy int
+
+const (
+	DUPLICATE_REJECT DuplicatePolicy = iota // fails with ERR_OVERLAPPED
+	DUPLICATE_ALLOW                         // keeps elements of equal keys
+)
+
+const (
+	defaultDegree     = 32
+	defaultFillFactor = 1.0
+)
+
+type config struct {
+	internalDegree int
+	leafCapacity   int
+	maxDepth       int // negative for no cap
+
+	duplicates DuplicatePolicy
+	fillFactor float64
+
+	observer Observer
+	clock    Clock
+	codec    Codec
+	hashing  bool
+	monoid   *Monoid
+
+	intervals bool
+}
+
+// Option configures a tree made by New.
+type Option func(c *config)
+
+// WithDegree makes internal nodes have maxDegree children and leaves have
+// maxDegree-1 elements at most, like NewBptree. Defaults to 32.
+func WithDegree(maxDegree int) Option {
+	return func(c *config) {
+		c.internalDegree = maxDegree
+		c.leafCapacity = maxDegree - 1
+	}
+}
+
+// WithInternalDegree makes internal nodes have maxDegree children at most,
+// regardless of leaves.
+func WithInternalDegree(maxDegree int) Option {
+	return func(c *config) {
+		c.internalDegree = maxDegree
+	}
+}
+
+// WithLeafCapacity makes leaves have maxElems elements at most, regardless
+// of internal nodes.
+func WithLeafCapacity(maxElems int) Option {
+	return func(c *config) {
+		c.leafCapacity = maxElems
+	}
+}
+
+// WithMaxDepth caps depth of the tree, so insertion into a tree deeper than
+// maxDepth fails with ERR_EXCEED_MAX_DEPTH. No cap by default.
+func WithMaxDepth(maxDepth int) Option {
+	return func(c *config) {
+		c.maxDepth = maxDepth
+	}
+}
+
+// WithDuplicates decides what happens on inserting a key already in the
+// tree. Defaults to DUPLICATE_REJECT.
+func WithDuplicates(policy DuplicatePolicy) Option {
+	return func(c *config) {
+		c.duplicates = policy
+	}
+}
+
+// WithFillFactor makes BulkLoad fill nodes to the factor of their capacity,
+// in (0, 1]. Defaults to 1, packing nodes full for read mostly trees; lower
+// one leaves room for later insertions without splits.
+func WithFillFactor(fillFactor float64) Option {
+	return func(c *config) {
+		c.fillFactor = fillFactor
+	}
+}
+
+func WithObserver(observer Observer) Option {
+	return func(c *config) {
+		c.observer = observer
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithCodec sets codec used to replicate the tree when NewPrimary is given
+// no codec.
+func WithCodec(codec Codec) Option {
+	return func(c *config) {
+		c.codec = codec
+	}
+}
+
+// New makes a tree configured by opts. Without any, internal nodes have 32
+// children and leaves 31 elements at most, depth is not capped and
+// duplicated keys are rejected.
+func New(opts ...Option) (*Bptree, error) {
+	c := config{
+		internalDegree: defaultDegree,
+		leafCapacity:   defaultDegree - 1,
+		maxDepth:       -1,
+		duplicates:     DUPLICATE_REJECT,
+		fillFactor:     defaultFillFactor,
+		clock:          systemClock{},
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.internalDegree < 3 {
+		return nil, errors.New("max degree must to have more than 3")
+	}
+
+	if c.leafCapacity < 2 {
+		return nil, errors.New("leaf capacity must to have more than 2")
+	}
+
+	switch c.duplicates {
+	case DUPLICATE_REJECT, DUPLICATE_ALLOW:
+	default:
+		return nil, errors.New("unknown duplicate policy")
+	}
+
+	if c.fillFactor <= 0 || c.fillFactor > 1 {
+		return nil, errors.New("fill factor must to be in (0, 1]")
+	}
+
+	if c.hashing && c.codec == nil {
+		return nil, errors.New("codec must be specified for hashing")
+	}
+
+	if c.monoid != nil && (c.monoid.Combine == nil || c.monoid.Value == nil) {
+		return nil, errors.New("combine and value functions must be specified")
+	}
+
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+
+	tree := &Bptree{
+		maxDegree:    c.internalDegree,
+		leafCapacity: c.leafCapacity,
+		maxDepth:     c.maxDepth,
+		allowOverlap: c.duplicates == DUPLICATE_ALLOW,
+		fillFactor:   c.fillFactor,
+		observer:     c.observer,
+		clock:        c.clock,
+		codec:        c.codec,
+		hashing:      c.hashing,
+		monoid:       c.monoid,
+		intervals:    c.intervals,
+		recencyLock:  new(sync.Mutex),
+		lock:         new(sync.RWMutex),
+		initialized:  true,
+	}
+
+	tree.monoids = monoidsOf(tree.monoid, tree.intervals)
+
+	return tree, nil
+}
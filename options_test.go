@@ -0,0 +1,127 @@
+package bptree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewDefaults(t *testing.T) {
+	tree, err := New()
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	if tree.maxChildren(true) != 32 || tree.maxChildren(false) != 31 || tree.allowOverlap || tree.maxDepth >= 0 {
+		t.Errorf("unexpected defaults: %d, %d, %v, %d", tree.maxChildren(true), tree.maxChildren(false), tree.allowOverlap, tree.maxDepth)
+	}
+
+	// no cap of depth
+	tree, _ = New(WithDegree(3))
+
+	for i := 0; i < 1<<17; i++ {
+		err = tree.Insert(&testElem{i})
+		if err != nil {
+			t.Errorf("while inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	if tree.Stats().Height <= _maxDepth {
+		t.Errorf("tree must grow deeper than %d, but %d", _maxDepth, tree.Stats().Height)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	codec := testCodec{}
+	clock := &testClock{}
+
+	tree, err := New(WithDegree(4), WithMaxDepth(1), WithDuplicates(DUPLICATE_ALLOW), WithCodec(codec), WithClock(clock))
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	if tree.codec != codec || tree.clock != clock {
+		t.Errorf("codec and clock must be set")
+	}
+
+	tree.Insert(&testElem{1})
+
+	err = tree.Insert(&testElem{1})
+	if err != nil {
+		t.Errorf("duplicated key must be allowed: %v", err)
+	}
+
+	for i := 0; i < 100 && err == nil; i++ {
+		err = tree.Insert(&testElem{i})
+	}
+
+	if !errors.Is(err, ERR_EXCEED_MAX_DEPTH) {
+		t.Errorf("depth must be capped, but %v", err)
+	}
+
+	// primary takes codec of the tree
+	_, err = NewPrimary(tree, nil, 0)
+	if err != nil {
+		t.Errorf("primary must use codec of the tree: %v", err)
+	}
+
+	invalids := [][]Option{
+		{WithDegree(2)},
+		{WithInternalDegree(2)},
+		{WithLeafCapacity(1)},
+		{WithDuplicates(DuplicatePolicy(100))},
+		{WithFillFactor(0)},
+		{WithFillFactor(1.5)},
+	}
+
+	for _, opts := range invalids {
+		_, err = New(opts...)
+		if err == nil {
+			t.Errorf("invalid options must be rejected")
+		}
+	}
+}
+
+func TestSeparateDegrees(t *testing.T) {
+	combinations := [][2]int{{2, 3}, {2, 64}, {64, 3}, {5, 5}, {100, 8}}
+
+	for _, c := range combinations {
+		leafCapacity, internalDegree := c[0], c[1]
+
+		tree, err := New(WithLeafCapacity(leafCapacity), WithInternalDegree(internalDegree))
+		if err != nil {
+			t.Errorf("while creating bptree: %v", err)
+			t.FailNow()
+		}
+
+		for i := 0; i < 5000; i++ {
+			tree.Insert(&testElem{i})
+		}
+
+		err = tree.Verify()
+		if err != nil {
+			t.Errorf("leaf %d, internal %d: %v", leafCapacity, internalDegree, err)
+			t.FailNow()
+		}
+
+		// sequential insertion leaves every leaf but the last half full
+		stats := tree.Stats()
+		leaves := (5000 + leafCapacity/2) / (leafCapacity - leafCapacity/2)
+
+		if stats.LeafNodes > leaves+1 || stats.LeafNodes < 5000/leafCapacity {
+			t.Errorf("leaf %d, internal %d: unexpected %d leaves", leafCapacity, internalDegree, stats.LeafNodes)
+		}
+
+		for i := 0; i < 5000; i += 2 {
+			tree.Remove(testKey(i))
+		}
+
+		err = tree.Verify()
+		if err != nil {
+			t.Errorf("leaf %d, internal %d: %v", leafCapacity, internalDegree, err)
+			t.FailNow()
+		}
+	}
+}
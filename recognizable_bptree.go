@@ -6,6 +6,12 @@ import (
 	"time"
 )
 
+// events sent to watchers
+const (
+	EVENT_MODIFIED = 1
+	EVENT_EXPIRED  = 2
+)
+
 // Instantly recognizable when tree changed
 type RecognizableBptree struct {
 	*Bptree
@@ -22,12 +28,25 @@ func NewRecognizableBptree(maxDegree, maxDepth int, allowOverlap bool) (*Recogni
 		return nil, err
 	}
 
-	return &RecognizableBptree{
+	tree := &RecognizableBptree{
 		Bptree:           bptree,
 		lastModified:     -1,
 		lastModifiedLock: new(sync.RWMutex),
 		notifyQueue:      lfreequeue.NewQueue(),
-	}, nil
+	}
+
+	bptree.OnExpire(func(elems Elems) {
+		// expiration happens under locks held by Insert, so don't wait for them
+		go func() {
+			tree.lastModifiedLock.Lock()
+			tree.lastModified = time.Now().UnixNano()
+			tree.lastModifiedLock.Unlock()
+
+			tree.notify(EVENT_EXPIRED)
+		}()
+	})
+
+	return tree, nil
 }
 
 func (tree *RecognizableBptree) GetLastModified() int64 {
@@ -44,12 +63,12 @@ func (tree *RecognizableBptree) AddWatch() <-chan int {
 	return ch
 }
 
-func (tree *RecognizableBptree) notify() {
+func (tree *RecognizableBptree) notify(event int) {
 	for v := range tree.notifyQueue.Iter() {
 		ch := v.(chan int)
 
 		go func() {
-			ch <- 1
+			ch <- event
 		}()
 	}
 }
@@ -65,7 +84,7 @@ func (tree *RecognizableBptree) Insert(elem Elem) error {
 		return err
 	}
 
-	tree.notify()
+	tree.notify(EVENT_MODIFIED)
 
 	return nil
 }
@@ -81,7 +100,57 @@ func (tree *RecognizableBptree) Remove(key Key) error {
 		return err
 	}
 
-	tree.notify()
+	tree.notify(EVENT_MODIFIED)
 
 	return nil
 }
+
+func (tree *RecognizableBptree) InsertWithTTL(elem Elem, ttl time.Duration) error {
+	tree.lastModifiedLock.Lock()
+	defer tree.lastModifiedLock.Unlock()
+
+	tree.lastModified = time.Now().UnixNano()
+
+	err := tree.Bptree.InsertWithTTL(elem, ttl)
+	if err != nil {
+		return err
+	}
+
+	tree.notify(EVENT_MODIFIED)
+
+	return nil
+}
+
+func (tree *RecognizableBptree) Clear() error {
+	tree.lastModifiedLock.Lock()
+	defer tree.lastModifiedLock.Unlock()
+
+	tree.lastModified = time.Now().UnixNano()
+
+	err := tree.Bptree.Clear()
+	if err != nil {
+		return err
+	}
+
+	tree.notify(EVENT_MODIFIED)
+
+	return nil
+}
+
+// SplitAt splits the tree like Bptree.SplitAt, which empties the tree, and
+// notifies watchers of the tree.
+func (tree *RecognizableBptree) SplitAt(key Key) (left, right *Bptree, err error) {
+	tree.lastModifiedLock.Lock()
+	defer tree.lastModifiedLock.Unlock()
+
+	tree.lastModified = time.Now().UnixNano()
+
+	left, right, err = tree.Bptree.SplitAt(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree.notify(EVENT_MODIFIED)
+
+	return left, right, nil
+}
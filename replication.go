@@ -0,0 +1,828 @@
+package bptree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Replication streams a primary tree to read-only replicas over any
+// io.ReadWriter. The replica opens the conversation with a hello frame
+// carrying the primary epoch and the last position it applied; the primary
+// answers with either a resume frame (the replica is caught up from the
+// mutation log) or a snapshot followed by the log. Every frame is
+//
+//	type(1 byte) | payload length(uvarint) | payload
+//
+// and integers inside payloads are uvarints. Deadlines of elements inserted
+// with time to live are unix nanos by the clock of the primary, zero for
+// none, so replicas hide expired elements as long as clocks agree.
+
+const (
+	msgHello    byte = 'H' // replica -> primary: epoch, position
+	msgResume   byte = 'C' // primary -> replica: epoch, position
+	msgSnapshot byte = 'S' // primary -> replica: epoch, position, count
+	msgElem     byte = 'E' // primary -> replica: deadline, encoded element of snapshot
+	msgInsert   byte = 'I' // primary -> replica: position, deadline, encoded element
+	msgRemove   byte = 'R' // primary -> replica: position, encoded element
+	msgClear    byte = 'X' // primary -> replica: position
+
+	maxFrameSize = 64 << 20
+
+	defaultLogSize = 4096
+)
+
+var (
+	// errors
+	ERR_PRIMARY_CLOSED   = errors.New("primary is closed")
+	ERR_REPLICA_LAGGED   = errors.New("replica lagged behind mutation log")
+	ERR_PROTOCOL         = errors.New("replication protocol violated")
+	ERR_FRAME_TOO_LARGE  = errors.New("replication frame too large")
+	ERR_REPLICA_DIVERGED = errors.New("replica diverged from primary")
+)
+
+type logEntry struct {
+	seq  uint64
+	op   byte
+	data []byte
+	err  error // of encoding, delivered to replicas instead
+}
+
+// Primary streams a tree to replicas by Serve. Every element stored into or
+// removed from the tree is recorded in an ordered log, whether it comes from
+// methods of Primary, eviction, expiration or methods of the tree called
+// directly.
+type Primary struct {
+	tree *Bptree
+
+	codec Codec
+	epoch uint64
+
+	// mutation log is a ring buffer holding positions (seq-len(log), seq]
+	log     []logEntry
+	logHead int
+	logLen  int
+	seq     uint64
+
+	closed bool
+
+	// lock order is tree lock first, since mutations are recorded while
+	// the tree is write locked
+	lock *sync.Mutex
+	cond *sync.Cond
+}
+
+// NewPrimary wraps tree for replication. Elements already in tree are
+// delivered to replicas as part of the initial snapshot. logSize bounds the
+// number of mutations kept for catching up reconnecting replicas, zero means
+// a default size. nil codec means the one given to the tree by WithCodec.
+// A tree can be wrapped by a single primary.
+func NewPrimary(tree *Bptree, codec Codec, logSize int) (*Primary, error) {
+	if tree == nil || !tree.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	if codec == nil {
+		codec = tree.codec
+	}
+
+	if codec == nil {
+		return nil, errors.New("codec must be specified")
+	}
+
+	if logSize < 0 {
+		return nil, errors.New("log size must to have zero or a positive value")
+	}
+
+	if logSize == 0 {
+		logSize = defaultLogSize
+	}
+
+	lock := new(sync.Mutex)
+
+	p := &Primary{
+		tree:  tree,
+		codec: codec,
+		epoch: uint64(rand.New(rand.NewSource(time.Now().UnixNano())).Int63()),
+		log:   make([]logEntry, logSize),
+		lock:  lock,
+		cond:  sync.NewCond(lock),
+	}
+
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.journal != nil {
+		return nil, errors.New("tree is already replicated")
+	}
+
+	tree.journal = p.record
+
+	return p, nil
+}
+
+// Position returns position of the last recorded mutation.
+func (p *Primary) Position() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.seq
+}
+
+func (p *Primary) Search(key Key) (res *SearchResult, ok bool, err error) {
+	return p.tree.Search(key)
+}
+
+func (p *Primary) SearchElem(key Key) (elem Elem, ok bool, err error) {
+	return p.tree.SearchElem(key)
+}
+
+func (p *Primary) SearchNearby(key Key, direction Direction) (res *SearchResult, equal bool, err error) {
+	return p.tree.SearchNearby(key, direction)
+}
+
+func (p *Primary) SearchElemNearby(key Key, direction Direction) (elem Elem, equal bool, err error) {
+	return p.tree.SearchElemNearby(key, direction)
+}
+
+func (p *Primary) Len() int {
+	return p.tree.Len()
+}
+
+func (p *Primary) Insert(elem Elem) error {
+	// fail before the mutation rather than in the log
+	_, err := p.codec.EncodeElem(elem)
+	if err != nil {
+		return err
+	}
+
+	if p.isClosed() {
+		return ERR_PRIMARY_CLOSED
+	}
+
+	return p.tree.Insert(elem)
+}
+
+func (p *Primary) InsertWithTTL(elem Elem, ttl time.Duration) error {
+	// fail before the mutation rather than in the log
+	_, err := p.codec.EncodeElem(elem)
+	if err != nil {
+		return err
+	}
+
+	if p.isClosed() {
+		return ERR_PRIMARY_CLOSED
+	}
+
+	return p.tree.InsertWithTTL(elem, ttl)
+}
+
+// ExpireNow removes expired elements like the tree does, which reach
+// replicas as removals.
+func (p *Primary) ExpireNow() int {
+	if p.isClosed() {
+		return 0
+	}
+
+	return p.tree.ExpireNow()
+}
+
+func (p *Primary) Remove(key Key) error {
+	if p.isClosed() {
+		return ERR_PRIMARY_CLOSED
+	}
+
+	return p.tree.Remove(key)
+}
+
+func (p *Primary) Clear() error {
+	if p.isClosed() {
+		return ERR_PRIMARY_CLOSED
+	}
+
+	return p.tree.Clear()
+}
+
+// Close stops accepting mutations and terminates all Serve calls.
+func (p *Primary) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+func (p *Primary) isClosed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.closed
+}
+
+// record appends a mutation to the log, caller must hold tree lock
+func (p *Primary) record(op byte, stored Elem) {
+	var data []byte
+	var err error
+
+	switch op {
+	case msgInsert:
+		data, err = encodeStored(p.codec, stored)
+	case msgRemove:
+		data, err = p.codec.EncodeElem(unwrapElem(stored))
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.seq += 1
+
+	tail := (p.logHead + p.logLen) % len(p.log)
+	p.log[tail] = logEntry{seq: p.seq, op: op, data: data, err: err}
+
+	if p.logLen < len(p.log) {
+		p.logLen += 1
+	} else {
+		p.logHead = (p.logHead + 1) % len(p.log)
+	}
+
+	p.cond.Broadcast()
+}
+
+// entriesFrom copies log entries beginning at position from, caller must hold p.lock
+func (p *Primary) entriesFrom(from uint64) (entries []logEntry, ok bool) {
+	oldest := p.seq - uint64(p.logLen) + 1
+
+	if from < oldest {
+		return nil, false
+	}
+
+	for seq := from; seq <= p.seq; seq++ {
+		idx := (p.logHead + int(seq-oldest)) % len(p.log)
+		entries = append(entries, p.log[idx])
+	}
+
+	return entries, true
+}
+
+// Serve streams the tree to a single replica connected through rw until the
+// connection fails or the primary is closed. It is safe to serve many
+// replicas concurrently.
+func (p *Primary) Serve(rw io.ReadWriter) error {
+	br := bufio.NewReader(rw)
+	bw := bufio.NewWriter(rw)
+
+	typ, payload, err := readFrame(br)
+	if err != nil {
+		return err
+	}
+
+	if typ != msgHello {
+		return ERR_PROTOCOL
+	}
+
+	epoch, position, err := decodePair(payload)
+	if err != nil {
+		return err
+	}
+
+	var next uint64
+	var snapshot Elems
+
+	// nothing is recorded while the tree is read locked
+	p.tree.lock.RLock()
+	p.lock.Lock()
+
+	if epoch == p.epoch && position <= p.seq && position > 0 {
+		if _, ok := p.entriesFrom(position + 1); ok {
+			next = position + 1
+		}
+	}
+
+	if next == 0 {
+		// replica could not be caught up from the log, so take a snapshot
+		// consistent with the current position
+		snapshot = p.snapshot()
+		next = p.seq + 1
+	}
+
+	p.lock.Unlock()
+	p.tree.lock.RUnlock()
+
+	if snapshot != nil {
+		err = p.writeSnapshot(bw, snapshot, next-1)
+	} else {
+		err = writeFrame(bw, msgResume, appendUvarints(nil, p.epoch, next-1))
+	}
+
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// watch the connection, replicas never talk after hello so any read
+	// result means the stream is gone
+	var gone bool
+
+	go func() {
+		io.Copy(io.Discard, br)
+
+		p.lock.Lock()
+		gone = true
+		p.cond.Broadcast()
+		p.lock.Unlock()
+	}()
+
+	for {
+		p.lock.Lock()
+
+		for next > p.seq && !p.closed && !gone {
+			p.cond.Wait()
+		}
+
+		if p.closed {
+			p.lock.Unlock()
+			return ERR_PRIMARY_CLOSED
+		}
+
+		if gone {
+			p.lock.Unlock()
+			return io.EOF
+		}
+
+		entries, ok := p.entriesFrom(next)
+
+		p.lock.Unlock()
+
+		if !ok {
+			return ERR_REPLICA_LAGGED
+		}
+
+		for _, entry := range entries {
+			if entry.err != nil {
+				return entry.err
+			}
+
+			payload := appendUvarints(nil, entry.seq)
+			payload = append(payload, entry.data...)
+
+			err = writeFrame(bw, entry.op, payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = bw.Flush()
+		if err != nil {
+			return err
+		}
+
+		next = entries[len(entries)-1].seq + 1
+	}
+}
+
+// snapshot collects every stored element in order, including expired ones
+// not removed yet since their removals are to be recorded, caller must hold
+// tree lock
+func (p *Primary) snapshot() Elems {
+	elems := make(Elems, 0, p.tree.count)
+
+	for node := p.tree.firstLeaf(); node != nil; node = node.next {
+		elems = append(elems, node.children...)
+	}
+
+	return elems
+}
+
+func (p *Primary) writeSnapshot(w io.Writer, elems Elems, position uint64) error {
+	err := writeFrame(w, msgSnapshot, appendUvarints(nil, p.epoch, position, uint64(len(elems))))
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range elems {
+		data, err := encodeStored(p.codec, elem)
+		if err != nil {
+			return err
+		}
+
+		err = writeFrame(w, msgElem, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replica is a read-only copy of a primary tree, kept up to date by Follow.
+type Replica struct {
+	tree *Bptree
+
+	opts []Option // of trees holding snapshots
+
+	codec Codec
+
+	epoch    uint64
+	position uint64
+
+	lock *sync.RWMutex
+}
+
+func NewReplica(maxDegree, maxDepth int, allowOverlap bool, codec Codec) (*Replica, error) {
+	opts, err := positionalOptions(maxDegree, maxDepth, allowOverlap)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplicaWithOptions(codec, opts...)
+}
+
+// NewReplicaWithOptions makes a replica whose trees are configured by opts
+// like New, which should match the primary tree for the snapshot to fit.
+func NewReplicaWithOptions(codec Codec, opts ...Option) (*Replica, error) {
+	tree, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec == nil {
+		return nil, errors.New("codec must be specified")
+	}
+
+	return &Replica{
+		tree:  tree,
+		opts:  opts,
+		codec: codec,
+		lock:  new(sync.RWMutex),
+	}, nil
+}
+
+// Position returns position of the last mutation applied from the primary.
+func (r *Replica) Position() uint64 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.position
+}
+
+func (r *Replica) current() *Bptree {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.tree
+}
+
+func (r *Replica) Search(key Key) (res *SearchResult, ok bool, err error) {
+	return r.current().Search(key)
+}
+
+func (r *Replica) SearchElem(key Key) (elem Elem, ok bool, err error) {
+	return r.current().SearchElem(key)
+}
+
+func (r *Replica) SearchNearby(key Key, direction Direction) (res *SearchResult, equal bool, err error) {
+	return r.current().SearchNearby(key, direction)
+}
+
+func (r *Replica) SearchElemNearby(key Key, direction Direction) (elem Elem, equal bool, err error) {
+	return r.current().SearchElemNearby(key, direction)
+}
+
+// Follow connects to a primary through rw and applies its stream until the
+// connection fails. Calling Follow again with a new connection resumes from
+// the applied position when the primary still holds it in its log. Follow
+// must not be called concurrently on the same replica.
+func (r *Replica) Follow(rw io.ReadWriter) error {
+	br := bufio.NewReader(rw)
+	bw := bufio.NewWriter(rw)
+
+	r.lock.RLock()
+	hello := appendUvarints(nil, r.epoch, r.position)
+	r.lock.RUnlock()
+
+	err := writeFrame(bw, msgHello, hello)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	typ, payload, err := readFrame(br)
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case msgResume:
+		epoch, position, err := decodePair(payload)
+		if err != nil {
+			return err
+		}
+
+		r.lock.RLock()
+		ok := epoch == r.epoch && position == r.position
+		r.lock.RUnlock()
+
+		if !ok {
+			return ERR_PROTOCOL
+		}
+
+	case msgSnapshot:
+		err = r.loadSnapshot(br, payload)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return ERR_PROTOCOL
+	}
+
+	for {
+		typ, payload, err = readFrame(br)
+		if err != nil {
+			return err
+		}
+
+		err = r.apply(typ, payload)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replica) loadSnapshot(br *bufio.Reader, payload []byte) error {
+	vals, n, err := decodeUvarints(payload, 3)
+	if err != nil {
+		return err
+	}
+
+	if n != len(payload) {
+		return ERR_PROTOCOL
+	}
+
+	epoch, position, count := vals[0], vals[1], vals[2]
+
+	tree, err := New(r.opts...)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		typ, data, err := readFrame(br)
+		if err != nil {
+			return err
+		}
+
+		if typ != msgElem {
+			return ERR_PROTOCOL
+		}
+
+		elem, deadline, err := decodeStored(r.codec, data)
+		if err != nil {
+			return err
+		}
+
+		err = tree.replay(msgInsert, elem, deadline, nil, r.codec)
+		if err != nil {
+			return fmt.Errorf("%v: %v", ERR_REPLICA_DIVERGED, err)
+		}
+	}
+
+	r.lock.Lock()
+	r.tree = tree
+	r.epoch = epoch
+	r.position = position
+	r.lock.Unlock()
+
+	return nil
+}
+
+func (r *Replica) apply(typ byte, payload []byte) error {
+	vals, n, err := decodeUvarints(payload, 1)
+	if err != nil {
+		return err
+	}
+
+	seq, data := vals[0], payload[n:]
+
+	r.lock.RLock()
+	tree, expected := r.tree, r.position+1
+	r.lock.RUnlock()
+
+	if seq != expected {
+		return ERR_PROTOCOL
+	}
+
+	var elem Elem
+	var deadline int64
+
+	switch typ {
+	case msgInsert:
+		elem, deadline, err = decodeStored(r.codec, data)
+		if err != nil {
+			return err
+		}
+
+	case msgRemove:
+		elem, err = r.codec.DecodeElem(data)
+		if err != nil {
+			return err
+		}
+
+	case msgClear:
+		if len(data) > 0 {
+			return ERR_PROTOCOL
+		}
+
+	default:
+		return ERR_PROTOCOL
+	}
+
+	err = tree.replay(typ, elem, deadline, data, r.codec)
+	if err != nil {
+		return fmt.Errorf("%v: %v", ERR_REPLICA_DIVERGED, err)
+	}
+
+	r.lock.Lock()
+	r.position = seq
+	r.lock.Unlock()
+
+	return nil
+}
+
+// replay applies a mutation recorded by a primary as is, without hooks,
+// expiration or eviction which the primary records on its own. Insertion
+// keeps deadline of the element, and removal takes the element encoded to
+// data among those of equal keys.
+func (tree *Bptree) replay(op byte, elem Elem, deadline int64, data []byte, codec Codec) (err error) {
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	defer tree.mutated("replay", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case msgInsert:
+		stored := tree.wrap(elem)
+		if deadline != 0 {
+			stored = &entry{Elem: elem, deadline: deadline}
+		}
+
+		err = tree.seal(stored)
+		if err != nil {
+			return err
+		}
+
+		err = tree.place(stored)
+		if err != nil {
+			return err
+		}
+
+		tree.account(stored, 1)
+
+	case msgRemove:
+		paths, idx, err := tree.findWhere(elem.Key(), func(stored Elem) bool {
+			encoded, err := codec.EncodeElem(unwrapElem(stored))
+			return err == nil && bytes.Equal(encoded, data)
+		})
+		if err != nil {
+			return keyError("remove", elem.Key(), err)
+		}
+
+		return tree.removeAt(paths, idx)
+
+	case msgClear:
+		tree.reset()
+	}
+
+	return nil
+}
+
+// encodeStored encodes deadline of stored element followed by the element
+func encodeStored(codec Codec, stored Elem) ([]byte, error) {
+	var deadline int64
+
+	if e, ok := stored.(*entry); ok {
+		deadline = e.deadline
+	}
+
+	data, err := codec.EncodeElem(unwrapElem(stored))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(appendUvarints(nil, uint64(deadline)), data...), nil
+}
+
+func decodeStored(codec Codec, data []byte) (elem Elem, deadline int64, err error) {
+	vals, n, err := decodeUvarints(data, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	elem, err = codec.DecodeElem(data[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return elem, int64(vals[0]), nil
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	var hdr [1 + binary.MaxVarintLen64]byte
+
+	hdr[0] = typ
+	n := binary.PutUvarint(hdr[1:], uint64(len(payload)))
+
+	_, err := w.Write(hdr[:1+n])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(payload)
+	return err
+}
+
+func readFrame(br *bufio.Reader) (typ byte, payload []byte, err error) {
+	typ, err = br.ReadByte()
+	if err != nil {
+		return
+	}
+
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	if size > maxFrameSize {
+		err = ERR_FRAME_TOO_LARGE
+		return
+	}
+
+	// size is not trusted until read, so it doesn't preallocate
+	payload, err = io.ReadAll(io.LimitReader(br, int64(size)))
+	if err == nil && uint64(len(payload)) < size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
+}
+
+func appendUvarints(b []byte, vals ...uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	for _, v := range vals {
+		n := binary.PutUvarint(buf[:], v)
+		b = append(b, buf[:n]...)
+	}
+
+	return b
+}
+
+func decodeUvarints(b []byte, count int) (vals []uint64, n int, err error) {
+	vals = make([]uint64, count)
+
+	for i := 0; i < count; i++ {
+		v, m := binary.Uvarint(b[n:])
+		if m <= 0 {
+			return nil, 0, ERR_PROTOCOL
+		}
+
+		vals[i] = v
+		n += m
+	}
+
+	return
+}
+
+func decodePair(b []byte) (first, second uint64, err error) {
+	vals, n, err := decodeUvarints(b, 2)
+	if err != nil {
+		return
+	}
+
+	if n != len(b) {
+		err = ERR_PROTOCOL
+		return
+	}
+
+	return vals[0], vals[1], nil
+}
@@ -0,0 +1,432 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type testCodec struct{}
+
+func (testCodec) EncodeElem(elem Elem) ([]byte, error) {
+	return testCodec{}.EncodeKey(elem.Key())
+}
+
+func (testCodec) DecodeElem(data []byte) (Elem, error) {
+	key, err := testCodec{}.DecodeKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testElem{int(key.(testKey))}, nil
+}
+
+func (testCodec) EncodeKey(key Key) ([]byte, error) {
+	return binary.AppendVarint(nil, int64(key.(testKey))), nil
+}
+
+func (testCodec) DecodeKey(data []byte) (Key, error) {
+	v, n := binary.Varint(data)
+	if n <= 0 || n != len(data) {
+		return nil, fmt.Errorf("invalid key encoding")
+	}
+
+	return testKey(v), nil
+}
+
+func waitReplica(t *testing.T, replica *Replica, position uint64) {
+	deadline := time.Now().Add(time.Second)
+
+	for replica.Position() < position {
+		if time.Now().After(deadline) {
+			t.Errorf("replica stuck at %d, waiting for %d", replica.Position(), position)
+			t.FailNow()
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkReplica(t *testing.T, replica *Replica, present, absent []int) {
+	for _, v := range present {
+		_, ok, err := replica.SearchElem(testKey(v))
+		if err != nil || !ok {
+			t.Errorf("element %d must be replicated: %v", v, err)
+			t.FailNow()
+		}
+	}
+
+	for _, v := range absent {
+		_, ok, _ := replica.SearchElem(testKey(v))
+		if ok {
+			t.Errorf("element %d must not be replicated", v)
+			t.FailNow()
+		}
+	}
+}
+
+func newTestPrimary(t *testing.T, logSize int) *Primary {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	primary, err := NewPrimary(tree, testCodec{}, logSize)
+	if err != nil {
+		t.Errorf("while creating primary: %v", err)
+		t.FailNow()
+	}
+
+	return primary
+}
+
+func newTestReplica(t *testing.T) *Replica {
+	replica, err := NewReplica(4, _maxDepth, false, testCodec{})
+	if err != nil {
+		t.Errorf("while creating replica: %v", err)
+		t.FailNow()
+	}
+
+	return replica
+}
+
+func TestReplicationSnapshotAndStream(t *testing.T) {
+	primary := newTestPrimary(t, 0)
+	defer primary.Close()
+
+	// elements before replica connects are delivered by snapshot
+	for i := 0; i < 100; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	replica := newTestReplica(t)
+
+	pc, rc := net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	waitReplica(t, replica, primary.Position())
+
+	// and later ones by the mutation log
+	for i := 100; i < 200; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	for i := 0; i < 50; i++ {
+		primary.Remove(testKey(i))
+	}
+
+	waitReplica(t, replica, primary.Position())
+
+	present := make([]int, 0)
+	absent := make([]int, 0)
+
+	for i := 0; i < 200; i++ {
+		if i < 50 {
+			absent = append(absent, i)
+		} else {
+			present = append(present, i)
+		}
+	}
+
+	checkReplica(t, replica, present, absent)
+
+	if replica.Position() != 250 {
+		t.Errorf("replica position must be 250, but %d", replica.Position())
+	}
+}
+
+func TestReplicationCatchUp(t *testing.T) {
+	primary := newTestPrimary(t, 0)
+	defer primary.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("while listening: %v", err)
+		t.FailNow()
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				primary.Serve(conn)
+			}()
+		}
+	}()
+
+	replica := newTestReplica(t)
+
+	connect := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Errorf("while dialing: %v", err)
+			t.FailNow()
+		}
+
+		go replica.Follow(conn)
+		return conn
+	}
+
+	for i := 0; i < 10; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	conn := connect()
+	waitReplica(t, replica, primary.Position())
+	conn.Close()
+
+	// mutations while disconnected
+	for i := 10; i < 20; i++ {
+		primary.Insert(&testElem{i})
+	}
+	primary.Remove(testKey(0))
+
+	conn = connect()
+	defer conn.Close()
+
+	waitReplica(t, replica, primary.Position())
+
+	checkReplica(t, replica, []int{1, 9, 10, 19}, []int{0, 20})
+}
+
+func TestReplicationLaggedReplicaGetsSnapshot(t *testing.T) {
+	primary := newTestPrimary(t, 8)
+	defer primary.Close()
+
+	replica := newTestReplica(t)
+
+	pc, rc := net.Pipe()
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	primary.Insert(&testElem{0})
+	waitReplica(t, replica, primary.Position())
+	rc.Close()
+
+	// overflow the mutation log so resuming is impossible
+	for i := 1; i < 100; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	pc, rc = net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	waitReplica(t, replica, primary.Position())
+
+	checkReplica(t, replica, []int{0, 50, 99}, []int{100})
+}
+
+func TestReplicationRecordsEveryMutation(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	primary, err := NewPrimary(tree, testCodec{}, 0)
+	if err != nil {
+		t.Errorf("while creating primary: %v", err)
+		t.FailNow()
+	}
+	defer primary.Close()
+
+	_, err = NewPrimary(tree, testCodec{}, 0)
+	if err == nil {
+		t.Errorf("tree must not be wrapped by two primaries")
+		t.FailNow()
+	}
+
+	replica := newTestReplica(t)
+
+	pc, rc := net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	for i := 0; i < 20; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	// mutations not through the primary are recorded as well
+	tree.PopMin()
+	tree.PopMax()
+	tree.Insert(&testElem{100})
+
+	waitReplica(t, replica, primary.Position())
+	checkReplica(t, replica, []int{1, 18, 100}, []int{0, 19})
+
+	position := primary.Position()
+
+	tree.Clear()
+	tree.Insert(&testElem{200})
+
+	if primary.Position() != position+2 {
+		t.Errorf("clear and insert must be recorded, but position moved from %d to %d", position, primary.Position())
+		t.FailNow()
+	}
+
+	waitReplica(t, replica, primary.Position())
+	checkReplica(t, replica, []int{200}, []int{1, 18, 100})
+
+	err = replica.current().Verify()
+	if err != nil {
+		t.Errorf("invalid replica: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestReplicaWithOptions(t *testing.T) {
+	// no depth cap and small leaves make the primary deep
+	tree, err := New(WithDegree(3), WithLeafCapacity(2), WithDuplicates(DUPLICATE_ALLOW))
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 2999; i++ {
+		tree.Insert(&testElem{i / 2})
+	}
+
+	primary, err := NewPrimary(tree, testCodec{}, 0)
+	if err != nil {
+		t.Errorf("while creating primary: %v", err)
+		t.FailNow()
+	}
+	defer primary.Close()
+
+	replica, err := NewReplicaWithOptions(testCodec{}, WithDegree(3), WithLeafCapacity(2), WithDuplicates(DUPLICATE_ALLOW))
+	if err != nil {
+		t.Errorf("while creating replica: %v", err)
+		t.FailNow()
+	}
+
+	pc, rc := net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	// snapshot and then the log
+	primary.Insert(&testElem{1499})
+
+	waitReplica(t, replica, primary.Position())
+
+	if replica.current().Len() != 3000 {
+		t.Errorf("replica has %d elements, expected 3000", replica.current().Len())
+		t.FailNow()
+	}
+
+	err = replica.current().Verify()
+	if err != nil {
+		t.Errorf("invalid replica: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestReplicationTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+
+	tree, err := New(WithDegree(4), WithClock(clock))
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.InsertWithTTL(&testElem{i}, time.Minute)
+	}
+
+	primary, err := NewPrimary(tree, testCodec{}, 0)
+	if err != nil {
+		t.Errorf("while creating primary: %v", err)
+		t.FailNow()
+	}
+	defer primary.Close()
+
+	replica, err := NewReplicaWithOptions(testCodec{}, WithDegree(4), WithClock(clock))
+	if err != nil {
+		t.Errorf("while creating replica: %v", err)
+		t.FailNow()
+	}
+
+	pc, rc := net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	// deadlines come with the snapshot and the log
+	for i := 10; i < 20; i++ {
+		primary.InsertWithTTL(&testElem{i}, time.Hour)
+	}
+
+	primary.Insert(&testElem{100})
+
+	waitReplica(t, replica, primary.Position())
+	checkReplica(t, replica, []int{0, 10, 100}, nil)
+
+	clock.Advance(2 * time.Minute)
+
+	checkReplica(t, replica, []int{10, 19, 100}, []int{0, 9})
+
+	// and expired ones are removed by the primary
+	if n := primary.ExpireNow(); n != 10 {
+		t.Errorf("%d expired, expected 10", n)
+		t.FailNow()
+	}
+
+	waitReplica(t, replica, primary.Position())
+
+	if replica.current().Len() != 11 {
+		t.Errorf("replica has %d elements, expected 11", replica.current().Len())
+		t.FailNow()
+	}
+
+	clock.Advance(time.Hour)
+
+	checkReplica(t, replica, []int{100}, []int{10, 19})
+}
+
+func TestReplicationEviction(t *testing.T) {
+	primary := newTestPrimary(t, 0)
+	defer primary.Close()
+
+	primary.tree.SetCapacity(Capacity{MaxElems: 10, Policy: EVICT_LOWEST_KEY})
+
+	replica := newTestReplica(t)
+
+	pc, rc := net.Pipe()
+	defer rc.Close()
+
+	go primary.Serve(pc)
+	go replica.Follow(rc)
+
+	for i := 0; i < 15; i++ {
+		primary.Insert(&testElem{i})
+	}
+
+	waitReplica(t, replica, primary.Position())
+
+	checkReplica(t, replica, []int{5, 14}, []int{0, 4})
+
+	if replica.current().Len() != 10 {
+		t.Errorf("replica has %d elements, expected 10", replica.current().Len())
+		t.FailNow()
+	}
+}
@@ -1,7 +1,7 @@
 package bptree
 
 import (
-	"sync"
+	"math"
 )
 
 type Direction int
@@ -17,301 +17,169 @@ type SearchResult struct {
 
 	matchElem Elem
 
-	treeLock *sync.RWMutex
+	tree *Bptree
 }
 
 func (res *SearchResult) Elem() Elem {
 	return res.matchElem
 }
 
-func (res *SearchResult) ElemAt(offset int) (elem Elem, ok bool) {
-	var direction Direction
-	var totalRemained, remained int
-	var node *indexNode
-	var children Elems
+// position next to i-th element of node in leaves, nil node if no more
+func nextPos(node *indexNode, i int) (*indexNode, int) {
+	i += 1
 
-	// tree read lock
-	res.treeLock.RLock()
-	defer res.treeLock.RUnlock()
+	for node != nil && i >= len(node.children) {
+		node = node.next
+		i = 0
+	}
 
-	switch {
-	case offset == 0:
-		elem = res.Elem()
-		ok = true
-		return
-
-	case offset > 0:
-		direction = ToRight
-		totalRemained = offset
-
-		if res.i+1 == len(res.node.children) {
-			node = res.node.next
-			if node == nil {
-				return
-			}
-
-			children = node.children
-			remained = len(children)
-		} else {
-			node = res.node
-			children = node.children[res.i+1:]
-			remained = len(children)
-		}
+	return node, i
+}
 
-	case offset < 0:
-		direction = ToLeft
-		totalRemained = -offset
-
-		if res.i == 0 {
-			node = res.node.prev
-			if node == nil {
-				return
-			}
-
-			children = node.children
-			remained = len(children)
-		} else {
-			node = res.node
-			children = node.children[:res.i]
-			remained = len(children)
+// position previous to i-th element of node in leaves, nil node if no more
+func prevPos(node *indexNode, i int) (*indexNode, int) {
+	i -= 1
+
+	for node != nil && i < 0 {
+		node = node.prev
+
+		if node != nil {
+			i = len(node.children) - 1
 		}
 	}
 
-	for totalRemained > remained {
-		totalRemained -= remained
+	return node, i
+}
 
+// step moves to the nearest visible element in direction
+func (res *SearchResult) step(node *indexNode, i int, direction Direction, now int64) (*indexNode, int, Elem) {
+	for {
 		switch direction {
 		case ToRight:
-			node = node.next
+			node, i = nextPos(node, i)
 		case ToLeft:
-			node = node.prev
+			node, i = prevPos(node, i)
 		}
 
 		if node == nil {
-			return
+			return nil, -1, nil
 		}
 
-		children = node.children
-		remained = len(children)
+		elem, ok := res.tree.visible(node.children[i], now)
+		if ok {
+			return node, i, elem
+		}
 	}
+}
 
-	switch direction {
-	case ToRight:
-		elem = children[totalRemained-1]
-	case ToLeft:
-		elem = children[len(children)-totalRemained]
+// directionOf returns direction and distance of offset, math.MinInt taken
+// as math.MaxInt so as not to overflow
+func directionOf(offset int) (Direction, int) {
+	switch {
+	case offset == math.MinInt:
+		return ToLeft, math.MaxInt
+	case offset < 0:
+		return ToLeft, -offset
+	default:
+		return ToRight, offset
 	}
-
-	ok = true
-
-	return
 }
 
-func (res *SearchResult) ElemRange(offset int) (elems Elems, n int) {
-	var direction Direction
-	var totalRemained, remained int
-	var node *indexNode
-	var children Elems
-
+func (res *SearchResult) ElemAt(offset int) (elem Elem, ok bool) {
 	// tree read lock
-	res.treeLock.RLock()
-	defer res.treeLock.RUnlock()
+	res.tree.lock.RLock()
+	defer res.tree.lock.RUnlock()
+	defer res.tree.recovered("elem at", nil)
 
-	elems = append(elems, res.node.children[res.i]) // including at least search result
-	n = 1
+	if offset == 0 {
+		return res.Elem(), true
+	}
 
-	switch {
-	case offset == 0:
-		return
-
-	case offset > 0:
-		direction = ToRight
-		totalRemained = offset
-
-		if res.i+1 == len(res.node.children) {
-			node = res.node.next
-			if node == nil {
-				return
-			}
-
-			children = node.children
-			remained = len(children)
-		} else {
-			node = res.node
-			children = node.children[res.i+1:]
-			remained = len(children)
-		}
+	direction, offset := directionOf(offset)
 
-	case offset < 0:
-		direction = ToLeft
-		totalRemained = -offset
-
-		if res.i == 0 {
-			node = res.node.prev
-			if node == nil {
-				return
-			}
-
-			children = node.children
-			remained = len(children)
-		} else {
-			node = res.node
-			children = node.children[:res.i]
-			remained = len(children)
+	now := res.tree.now()
+	node, i := res.node, res.i
+
+	for ; offset > 0; offset-- {
+		node, i, elem = res.step(node, i, direction, now)
+		if node == nil {
+			return nil, false
 		}
 	}
 
-	for totalRemained > remained {
-		totalRemained -= remained
+	ok = true
 
-		switch direction {
-		case ToRight:
-			elems = append(elems, children...)
-			n += len(children)
+	return
+}
 
-			node = node.next
+func (res *SearchResult) ElemRange(offset int) (elems Elems, n int) {
+	// tree read lock
+	res.tree.lock.RLock()
+	defer res.tree.lock.RUnlock()
+	defer res.tree.recovered("elem range", nil)
 
-		case ToLeft:
-			elems = append(children, elems...)
-			n += len(children)
+	direction, offset := directionOf(offset)
 
-			node = node.prev
-		}
+	// offset may be far beyond elements in the tree
+	elems = make(Elems, 0, min(offset, res.tree.count)+1)
+	elems = append(elems, res.Elem()) // including at least search result
+
+	now := res.tree.now()
+	node, i := res.node, res.i
+
+	var elem Elem
 
+	for ; offset > 0; offset-- {
+		node, i, elem = res.step(node, i, direction, now)
 		if node == nil {
-			return
+			break
 		}
 
-		children = node.children
-		remained = len(children)
+		elems = append(elems, elem)
 	}
 
-	switch direction {
-	case ToRight:
-		remainder := children[:totalRemained]
-		elems = append(elems, remainder...)
-		n += len(remainder)
-
-	case ToLeft:
-		remainder := children[len(children)-totalRemained:]
-
-		elems = append(remainder, elems...)
-		n += len(remainder)
+	if direction == ToLeft {
+		elems.reverse()
 	}
 
+	n = len(elems)
+
 	return
 }
 
 func (res *SearchResult) ElemRangeTo(key Key, direction Direction, maxN int) (elems Elems, n int) {
-	var node *indexNode
-	var children Elems
-
 	// tree read lock
-	res.treeLock.RLock()
-	defer res.treeLock.RUnlock()
+	res.tree.lock.RLock()
+	defer res.tree.lock.RUnlock()
+	defer res.tree.recovered("elem range to", nil)
 
-	elems = append(elems, res.node.children[res.i]) // including at least search result
+	elems = append(elems, res.Elem()) // including at least search result
 	n = 1
 
-	if n > maxN {
-		return
+	// beyond the key elements are excluded
+	beyond := Cond(Greater)
+	if direction == ToLeft {
+		beyond = Less
 	}
 
-	switch direction {
-	case ToRight:
-		if res.i+1 == len(res.node.children) {
-			node = res.node.next
-			if node == nil {
-				return
-			}
-
-			children = node.children
-		} else {
-			node = res.node
-			children = node.children[res.i+1:]
-		}
-
-	case ToLeft:
-		if res.i == 0 {
-			node = res.node.prev
-			if node == nil {
-				return
-			}
-
-			children = node.children
-		} else {
-			node = res.node
-			children = node.children[:res.i]
-		}
-	}
-
-	var copyN int
-	var exit bool
-
-	for {
-		copyN = 0
-
-		switch direction {
-		case ToRight:
-			i, equal := children.find(key)
-
-			if i == len(children) {
-				copyN = len(children)
-			} else {
-				if equal {
-					copyN = i + 1
-				} else {
-					copyN = i
-				}
-
-				exit = true
-			}
-
-			if n+copyN > maxN {
-				copyN = maxN - n
-				exit = true
-			}
+	now := res.tree.now()
+	node, i := res.node, res.i
 
-			elems = append(elems, children[:copyN]...)
-			n += copyN
-
-			if exit {
-				return
-			}
-
-			node = node.next
-
-		case ToLeft:
-			i, equal := children.find(key)
+	var elem Elem
 
-			if i == 0 && !equal {
-				copyN = len(children)
-			} else {
-				copyN = len(children) - i
-				exit = true
-			}
-
-			if n+copyN > maxN {
-				copyN = maxN - n
-				exit = true
-			}
-
-			elems = append(children[len(children)-copyN:], elems...)
-			n += copyN
-
-			if exit {
-				return
-			}
-
-			node = node.prev
+	for n < maxN {
+		node, i, elem = res.step(node, i, direction, now)
+		if node == nil || elem.Key().CompareTo(key) == beyond {
+			break
 		}
 
-		if node == nil {
-			return
-		}
+		elems = append(elems, elem)
+		n += 1
+	}
 
-		children = node.children
+	if direction == ToLeft {
+		elems.reverse()
 	}
 
-	panic("never reached")
+	return
 }
@@ -0,0 +1,141 @@
+package bptree
+
+// Resolver chooses an element for a key both trees have, a from the tree and
+// b from the other.
+type Resolver func(a, b Elem) Elem
+
+// Union returns a new tree of elements in either tree. For a key in both,
+// resolve chooses the element; nil resolve keeps the one of the tree. Like
+// other set operations, the new tree is configured like the tree and holds
+// alive elements only, which never expire.
+func (tree *Bptree) Union(other *Bptree, resolve Resolver) (*Bptree, error) {
+	if resolve == nil {
+		resolve = keepFirst
+	}
+
+	return tree.combine("union", other, true, true, resolve)
+}
+
+// Intersect returns a new tree of elements of the tree whose keys are in
+// other as well.
+func (tree *Bptree) Intersect(other *Bptree) (*Bptree, error) {
+	return tree.combine("intersect", other, false, false, keepFirst)
+}
+
+// Difference returns a new tree of elements of the tree whose keys are not in
+// other.
+func (tree *Bptree) Difference(other *Bptree) (*Bptree, error) {
+	return tree.combine("difference", other, true, false, nil)
+}
+
+// SymmetricDifference returns a new tree of elements whose keys are in only
+// one of the trees.
+func (tree *Bptree) SymmetricDifference(other *Bptree) (*Bptree, error) {
+	return tree.combine("symmetric difference", other, true, true, nil)
+}
+
+func keepFirst(a, b Elem) Elem {
+	return a
+}
+
+// combine merges alive elements of both trees in order and builds a new tree
+// configured like the tree out of them. Elements of a key only in the tree or
+// other are kept if onlyA or onlyB, and a pair of equal keys is resolved by
+// both or dropped if nil. With overlapped keys, elements of a key are paired
+// in order and the rest are regarded as being only in their tree.
+//
+// Elements of other are read before the tree, so the trees are not combined
+// at a single point in time while they change.
+func (tree *Bptree) combine(op string, other *Bptree, onlyA, onlyB bool, both Resolver) (result *Bptree, err error) {
+	if !tree.initialized || !other.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	b, err := other.alive(op)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := tree.alive(op)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(Elems, 0, len(a)+len(b))
+
+	emit := func(elem Elem) {
+		last := len(merged) - 1
+
+		// the result may reject overlapped keys the other allows
+		if !tree.allowOverlap && last >= 0 && merged[last].Key().CompareTo(elem.Key()) == Equal {
+			if both != nil {
+				merged[last] = both(merged[last], elem)
+			}
+
+			return
+		}
+
+		merged = append(merged, elem)
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch a[i].Key().CompareTo(b[j].Key()) {
+		case Less:
+			if onlyA {
+				emit(a[i])
+			}
+			i += 1
+
+		case Greater:
+			if onlyB {
+				emit(b[j])
+			}
+			j += 1
+
+		default:
+			if both != nil {
+				emit(both(a[i], b[j]))
+			}
+			i += 1
+			j += 1
+		}
+	}
+
+	for ; onlyA && i < len(a); i++ {
+		emit(a[i])
+	}
+
+	for ; onlyB && j < len(b); j++ {
+		emit(b[j])
+	}
+
+	tree.lock.RLock()
+	result = tree.emptyCopy()
+	tree.lock.RUnlock()
+
+	err = result.load(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// alive returns alive elements of the tree in order
+func (tree *Bptree) alive(op string) (elems Elems, err error) {
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	defer tree.recovered(op, &err)
+
+	elems = make(Elems, 0, tree.count)
+
+	tree.ascend(func(elem Elem) bool {
+		elems = append(elems, elem)
+		return true
+	})
+
+	return elems, nil
+}
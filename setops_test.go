@@ -0,0 +1,185 @@
+package bptree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+type taggedElem struct {
+	key int
+	tag string
+}
+
+func (ele *taggedElem) Key() Key {
+	return testKey(ele.key)
+}
+
+func newSetOpsTree(t *testing.T, maxDegree int, keys []int, tag string) *Bptree {
+	tree, err := NewBptree(maxDegree, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for _, key := range keys {
+		tree.Insert(&taggedElem{key, tag})
+	}
+
+	return tree
+}
+
+func treeKeys(tree *Bptree) []int {
+	var keys []int
+
+	tree.ascend(func(elem Elem) bool {
+		keys = append(keys, int(elem.Key().(testKey)))
+		return true
+	})
+
+	return keys
+}
+
+func TestSetOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 50; round++ {
+		inA := make(map[int]bool)
+		inB := make(map[int]bool)
+
+		var aKeys, bKeys []int
+
+		for key := 0; key < 300; key++ {
+			if r.Intn(2) == 0 {
+				inA[key] = true
+				aKeys = append(aKeys, key)
+			}
+
+			if r.Intn(3) == 0 {
+				inB[key] = true
+				bKeys = append(bKeys, key)
+			}
+		}
+
+		a := newSetOpsTree(t, 3+r.Intn(8), aKeys, "a")
+		b := newSetOpsTree(t, 3+r.Intn(8), bKeys, "b")
+
+		expected := map[string]func(key int) bool{
+			"union":                func(key int) bool { return inA[key] || inB[key] },
+			"intersect":            func(key int) bool { return inA[key] && inB[key] },
+			"difference":           func(key int) bool { return inA[key] && !inB[key] },
+			"symmetric difference": func(key int) bool { return inA[key] != inB[key] },
+		}
+
+		results := make(map[string]*Bptree)
+
+		var err error
+
+		results["union"], err = a.Union(b, nil)
+		if err == nil {
+			results["intersect"], err = a.Intersect(b)
+		}
+		if err == nil {
+			results["difference"], err = a.Difference(b)
+		}
+		if err == nil {
+			results["symmetric difference"], err = a.SymmetricDifference(b)
+		}
+
+		if err != nil {
+			t.Errorf("round %d: %v", round, err)
+			t.FailNow()
+		}
+
+		for op, result := range results {
+			var want []int
+			for key := 0; key < 300; key++ {
+				if expected[op](key) {
+					want = append(want, key)
+				}
+			}
+
+			if got := treeKeys(result); !equalInts(got, want) {
+				t.Errorf("round %d: %s got %v, expected %v", round, op, got, want)
+				t.FailNow()
+			}
+
+			err = result.Verify()
+			if err != nil {
+				t.Errorf("round %d: invalid tree of %s: %v", round, op, err)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestUnionResolve(t *testing.T) {
+	a := newSetOpsTree(t, 4, []int{1, 2, 3}, "a")
+	b := newSetOpsTree(t, 4, []int{2, 3, 4}, "b")
+
+	result, err := a.Union(b, nil)
+	if err != nil {
+		t.Errorf("while union: %v", err)
+		t.FailNow()
+	}
+
+	elem, _, _ := result.SearchElem(testKey(2))
+	if elem.(*taggedElem).tag != "a" {
+		t.Errorf("union must keep element of the tree by default, but %v", elem)
+		t.FailNow()
+	}
+
+	result, err = a.Union(b, func(x, y Elem) Elem {
+		return &taggedElem{x.(*taggedElem).key, x.(*taggedElem).tag + y.(*taggedElem).tag}
+	})
+	if err != nil {
+		t.Errorf("while union: %v", err)
+		t.FailNow()
+	}
+
+	for key, tag := range map[int]string{1: "a", 2: "ab", 3: "ab", 4: "b"} {
+		elem, ok, _ := result.SearchElem(testKey(key))
+		if !ok || elem.(*taggedElem).tag != tag {
+			t.Errorf("element of %d must be tagged %q, but %v", key, tag, elem)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUnionOverlapped(t *testing.T) {
+	// the tree rejects overlapped keys the other has
+	a := newSetOpsTree(t, 4, []int{1, 2}, "a")
+
+	b, _ := NewBptree(4, _maxDepth, true)
+	for _, key := range []int{2, 2, 2, 3} {
+		b.Insert(&taggedElem{key, "b"})
+	}
+
+	result, err := a.Union(b, nil)
+	if err != nil {
+		t.Errorf("while union: %v", err)
+		t.FailNow()
+	}
+
+	if got := treeKeys(result); !equalInts(got, []int{1, 2, 3}) {
+		t.Errorf("union got %v, expected [1 2 3]", got)
+		t.FailNow()
+	}
+
+	// and the other way around
+	result, err = b.Union(a, nil)
+	if err != nil {
+		t.Errorf("while union: %v", err)
+		t.FailNow()
+	}
+
+	if got := treeKeys(result); !equalInts(got, []int{1, 2, 2, 2, 3}) {
+		t.Errorf("union got %v, expected [1 2 2 2 3]", got)
+		t.FailNow()
+	}
+
+	err = result.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,495 @@
+package bptree
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// joinLock serializes locking two trees by Join, so joins of the same trees
+// in different order don't deadlock
+var joinLock sync.Mutex
+
+// SplitAt cuts the tree into left of elements whose keys are less than key
+// and right of the others, both configured like the tree and running its
+// hooks, observer and reaper. Nodes are moved to them rather than copied, so
+// the tree becomes empty. Cutting along the path to key and taking over
+// bookkeeping of nodes takes O(log n). Only with least recently used
+// eviction, accesses of the smaller side are moved one by one.
+func (tree *Bptree) SplitAt(key Key) (left, right *Bptree, err error) {
+	if !tree.initialized {
+		return nil, nil, ERR_NOT_INITIALIZED
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.observer != nil {
+		defer tree.observe(OP_SPLIT, key, time.Now(), &err)
+	}
+
+	defer tree.mutated("split", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	left = tree.emptyCopy()
+	right = tree.emptyCopy()
+
+	tree.inherit(left)
+	tree.inherit(right)
+
+	if tree.root == nil {
+		return left, right, nil
+	}
+
+	// path to the first element not less than key
+	paths, idx := tree.findFirst(key)
+
+	lefts, rights := tree.cut(paths, idx)
+
+	// assemble pieces in order of keys
+	for i := 0; i < len(lefts); i++ {
+		left.join(lefts[i])
+	}
+
+	for i := len(rights) - 1; i >= 0; i-- {
+		right.join(rights[i])
+	}
+
+	left.settle()
+	right.settle()
+
+	tree.share(left, right)
+
+	tree.reset()
+
+	return left, right, nil
+}
+
+// Join concatenates left and right into a new tree configured like left and
+// running its hooks, observer and reaper. Keys of left must be less than
+// those of right, or not greater if they allow overlapped keys. Nodes are
+// moved rather than copied, so both become empty. Joining along edges of the
+// trees takes O(log n). Only with least recently used eviction, accesses of
+// the smaller one are moved one by one.
+func Join(left, right *Bptree) (joined *Bptree, err error) {
+	if !left.initialized || !right.initialized {
+		return nil, ERR_NOT_INITIALIZED
+	}
+
+	if left == right {
+		return nil, errors.New("tree can't be joined with itself")
+	}
+
+	// write locks
+	joinLock.Lock()
+	left.lock.Lock()
+	right.lock.Lock()
+	joinLock.Unlock()
+
+	defer left.lock.Unlock()
+	defer right.lock.Unlock()
+	defer left.mutated("join", &err)
+	defer right.mutated("join", &err)
+
+	err = left.writable()
+	if err == nil {
+		err = right.writable()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if left.maxDegree != right.maxDegree || left.leafCapacity != right.leafCapacity ||
+		left.allowOverlap != right.allowOverlap || left.hashing != right.hashing ||
+		!sameMonoid(left.monoid, right.monoid) || left.intervals != right.intervals ||
+		(left.recency == nil) != (right.recency == nil) {
+		return nil, errors.New("trees must be configured alike to join")
+	}
+
+	if left.count > 0 && right.count > 0 {
+		last := left.tail.children[len(left.tail.children)-1].Key()
+		first := right.head.children[0].Key()
+
+		switch last.CompareTo(first) {
+		case Greater:
+			return nil, errors.New("keys of left must not be greater than those of right")
+		case Equal:
+			if !left.allowOverlap {
+				return nil, keyError("join", first, ERR_OVERLAPPED)
+			}
+		}
+	}
+
+	// like insertion, joined tree could have max depth + 1
+	for _, tree := range []*Bptree{left, right} {
+		if left.maxDepth >= 0 && tree.root != nil && tree.root.depthToLeaf > left.maxDepth {
+			return nil, ERR_EXCEED_MAX_DEPTH
+		}
+	}
+
+	joined = left.emptyCopy()
+	left.inherit(joined)
+
+	joined.count = left.count + right.count
+	joined.bytes = left.bytes + right.bytes
+	joined.expiring = left.expiring + right.expiring
+
+	// the earlier, either may be zero for unknown
+	joined.nextDeadline = min(left.nextDeadline, right.nextDeadline)
+
+	if joined.recency != nil {
+		// accesses of the larger one are taken over
+		large, small := left, right
+		if small.count > large.count {
+			large, small = small, large
+		}
+
+		joined.recency = large.recency
+		small.moveAccesses(small.recency, joined.recency)
+	}
+
+	// leaves of both are linked from now on
+	joined.join(left.root)
+	joined.join(right.root)
+	joined.settle()
+
+	left.reset()
+	right.reset()
+
+	joined.evict()
+
+	return joined, nil
+}
+
+// inherit makes copied run hooks, observer and reaper of the tree as well,
+// caller must hold tree lock
+func (tree *Bptree) inherit(copied *Bptree) {
+	// clipped, so hooks added to either later don't show up in the other
+	copied.hooks = hooks{
+		beforeInsert: slices.Clip(tree.hooks.beforeInsert),
+		afterInsert:  slices.Clip(tree.hooks.afterInsert),
+		beforeRemove: slices.Clip(tree.hooks.beforeRemove),
+		afterRemove:  slices.Clip(tree.hooks.afterRemove),
+	}
+
+	copied.observer = tree.observer
+	copied.expireHooks = slices.Clip(tree.expireHooks)
+	copied.evictHooks = slices.Clip(tree.evictHooks)
+
+	if tree.reaperStop != nil {
+		copied.StartReaper(tree.reaperInterval)
+	}
+}
+
+// sameMonoid reports whether a and b aggregate alike, having the same
+// identity and functions
+func sameMonoid(a, b *Monoid) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return reflect.DeepEqual(a.Identity, b.Identity) &&
+		reflect.ValueOf(a.Combine).Pointer() == reflect.ValueOf(b.Combine).Pointer() &&
+		reflect.ValueOf(a.Value).Pointer() == reflect.ValueOf(b.Value).Pointer()
+}
+
+// cut severs every node along paths into pieces holding children before and
+// after the path, from root. The leaf at the end of paths is cut before its
+// idx-th element. Pieces are sub-trees of their own, unlinked from each
+// other, whose nodes but the pieces themselves fit.
+func (tree *Bptree) cut(paths []*indexNode, idx int) (lefts, rights []*indexNode) {
+	for k, node := range paths {
+		i := idx
+		if node.isInternal {
+			i = node.childIndex(paths[k+1])
+		}
+
+		tree.detach(node)
+
+		// node keeps the left, and a new node takes the right
+		l, r := node, &indexNode{
+			children:    make(Elems, len(node.children)-i, tree.maxChildren(node.isInternal)+1),
+			isInternal:  node.isInternal,
+			depthToLeaf: node.depthToLeaf,
+		}
+
+		if node.isInternal {
+			// child on the path is cut below
+			copy(r.children, node.children[i+1:])
+			r.children = r.children[:len(node.children)-i-1]
+		} else {
+			copy(r.children, node.children[i:])
+		}
+
+		l.children = node.children[:i]
+		l._tmpKey = nil
+
+		tree.refresh(l)
+		tree.refresh(r)
+
+		lefts = append(lefts, l)
+		rights = append(rights, r)
+	}
+
+	return
+}
+
+// detach unlinks node from its siblings, caller must hold tree lock
+func (tree *Bptree) detach(node *indexNode) {
+	if node.prev != nil {
+		node.prev.next = nil
+	}
+
+	if node.next != nil {
+		node.next.prev = nil
+	}
+
+	node.prev, node.next = nil, nil
+}
+
+// join appends sub-tree of root on the right of the tree. Keys of root must
+// not be less than those of the tree, and nodes of root except the root
+// itself must fit. Levels of both are linked along their edges, and the edge
+// of the tree is rebalanced where root joins.
+func (tree *Bptree) join(root *indexNode) {
+	b := tree.trim(root)
+	if b == nil {
+		return
+	}
+
+	a := tree.trim(tree.root)
+	if a == nil {
+		tree.root = b
+		return
+	}
+
+	aPaths := edgePathOf(a, ToRight)
+	bPaths := edgePathOf(b, ToLeft)
+
+	// link levels both have, from leaves
+	for k := 0; k < len(aPaths) && k < len(bPaths); k++ {
+		l, r := aPaths[len(aPaths)-1-k], bPaths[len(bPaths)-1-k]
+
+		l.next = r
+		r.prev = l
+	}
+
+	switch {
+	case a.depthToLeaf == b.depthToLeaf:
+		tree.root = a
+
+		if tree.even(a, b, false) {
+			tree.refresh(a)
+			return
+		}
+
+		// a new root over both
+		tree.root = &indexNode{
+			children:    make(Elems, 2, tree.maxChildren(true)+1),
+			isInternal:  true,
+			depthToLeaf: a.depthToLeaf + 1,
+		}
+
+		tree.root.children[0], tree.root.children[1] = a, b
+
+		tree.refresh(a)
+		tree.refresh(b)
+		tree.refresh(tree.root)
+
+	case a.depthToLeaf > b.depthToLeaf:
+		// b becomes the last child of the parent of edge node of its depth
+		tree.root = a
+		paths := aPaths[:a.depthToLeaf-b.depthToLeaf+1]
+		edge := paths[len(paths)-1]
+
+		merged := tree.even(edge, b, false)
+
+		tree.refresh(edge)
+
+		if !merged {
+			tree.refresh(b)
+
+			parent := paths[len(paths)-2]
+			parent.insertAt(len(parent.children), b, tree.maxChildren(true))
+		}
+
+		tree.rebalanceEdge(paths[:len(paths)-1])
+
+	default:
+		// a becomes the first child of the parent of edge node of its depth
+		tree.root = b
+		paths := bPaths[:b.depthToLeaf-a.depthToLeaf+1]
+		edge := paths[len(paths)-1]
+
+		merged := tree.even(a, edge, true)
+
+		tree.refresh(edge)
+
+		if !merged {
+			tree.refresh(a)
+
+			paths[len(paths)-2].insertAt(0, a, tree.maxChildren(true))
+		}
+
+		tree.rebalanceEdge(paths[:len(paths)-1])
+	}
+}
+
+// rebalanceEdge sums up and splits overflowed nodes along paths from leaves
+// up to root, like insertion does
+func (tree *Bptree) rebalanceEdge(paths []*indexNode) {
+	for i := len(paths) - 1; i >= 0; i-- {
+		path := paths[i]
+		tree.refresh(path)
+
+		if len(path.children) > tree.maxChildren(path.isInternal) {
+			tree.balance(paths[:i+1])
+		}
+	}
+}
+
+// even makes adjacent nodes l and r of the same depth fit. They are merged
+// into r if intoRight, otherwise into l, as long as the one fits all children
+// and it returns true. Otherwise children move to whichever underflows.
+func (tree *Bptree) even(l, r *indexNode, intoRight bool) bool {
+	most := tree.maxChildren(l.isInternal)
+	least := tree.minChildren(l.isInternal)
+
+	if len(l.children)+len(r.children) <= most {
+		if intoRight {
+			children := make(Elems, 0, most+1)
+			children = append(children, l.children...)
+			r.children = append(children, r.children...)
+
+			r.prev = l.prev
+			if l.prev != nil {
+				l.prev.next = r
+			}
+		} else {
+			l.children = append(l.children, r.children...)
+
+			l.next = r.next
+			if r.next != nil {
+				r.next.prev = l
+			}
+		}
+
+		return true
+	}
+
+	for len(l.children) < least {
+		l.children = append(l.children, r.children[0])
+		r.children = r.children[1:]
+	}
+
+	if n := least - len(r.children); n > 0 {
+		children := make(Elems, 0, most+1)
+		children = append(children, l.children[len(l.children)-n:]...)
+		r.children = append(children, r.children...)
+
+		l.children = l.children[:len(l.children)-n]
+	}
+
+	return false
+}
+
+// trim removes redundant roots having only one child, and returns nil for
+// empty one
+func (tree *Bptree) trim(root *indexNode) *indexNode {
+	for root != nil && root.isInternal && len(root.children) == 1 {
+		root = root.children[0].(*indexNode)
+	}
+
+	if root == nil || len(root.children) == 0 {
+		return nil
+	}
+
+	root.prev, root.next = nil, nil
+
+	return root
+}
+
+// settle unlinks edges of every level from nodes no longer in the tree and
+// finds edge leaves again, after the tree is assembled by join
+func (tree *Bptree) settle() {
+	tree.root = tree.trim(tree.root)
+
+	for _, node := range edgePathOf(tree.root, ToLeft) {
+		node.prev = nil
+	}
+
+	for _, node := range edgePathOf(tree.root, ToRight) {
+		node.next = nil
+	}
+
+	tree.cacheEdges()
+}
+
+// share divides bookkeeping of the tree between left and right, which took
+// its nodes, out of their roots
+func (tree *Bptree) share(left, right *Bptree) {
+	for _, side := range []*Bptree{left, right} {
+		if side.root != nil {
+			side.count = side.root.count
+			side.bytes = side.root.bytes
+			side.expiring = side.root.expiring
+		}
+
+		// no later than any deadline of the side
+		side.nextDeadline = tree.nextDeadline
+	}
+
+	if tree.recency == nil {
+		return
+	}
+
+	// accesses of the larger one are taken over
+	large, small := left, right
+	if small.count > large.count {
+		large, small = small, large
+	}
+
+	large.recency = tree.recency
+	small.moveAccesses(tree.recency, small.recency)
+}
+
+// moveAccesses moves accesses of elements of the tree from one recency list
+// to another in order of keys, caller must hold tree lock
+func (tree *Bptree) moveAccesses(from, to *list.List) {
+	for node := tree.head; node != nil; node = node.next {
+		for _, stored := range node.children {
+			e := stored.(*entry)
+
+			from.Remove(e.access)
+			e.access = to.PushFront(e)
+		}
+	}
+}
+
+// edgePathOf is edgePath of sub-tree of node
+func edgePathOf(node *indexNode, direction Direction) (paths []*indexNode) {
+	for node != nil {
+		paths = append(paths, node)
+
+		if !node.isInternal {
+			break
+		}
+
+		if direction == ToLeft {
+			node = node.children[0].(*indexNode)
+		} else {
+			node = node.children[len(node.children)-1].(*indexNode)
+		}
+	}
+
+	return
+}
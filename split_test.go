@@ -0,0 +1,535 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSplitAtJoin(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, maxDegree := range []int{3, 4, 7} {
+		for _, allowOverlap := range []bool{false, true} {
+			duplicates := DUPLICATE_REJECT
+			if allowOverlap {
+				duplicates = DUPLICATE_ALLOW
+			}
+
+			for round := 0; round < 30; round++ {
+				tree, err := New(WithDegree(maxDegree), WithDuplicates(duplicates),
+					WithCodec(testCodec{}), WithMerkle(), WithAggregate(sumMonoid))
+				if err != nil {
+					t.Errorf("while creating bptree: %v", err)
+					t.FailNow()
+				}
+
+				var keys []int
+
+				n := r.Intn(400)
+				for i := 0; i < n; i++ {
+					key := r.Intn(300)
+
+					if tree.Insert(&testElem{key}) == nil {
+						keys = append(keys, key)
+					}
+				}
+
+				whole, _ := tree.Clone()
+				hash, _ := tree.RootHash()
+
+				at := r.Intn(320) - 10
+
+				left, right, err := tree.SplitAt(testKey(at))
+				if err != nil {
+					t.Errorf("while splitting at %d: %v", at, err)
+					t.FailNow()
+				}
+
+				var lefts, rights int
+				for _, key := range keys {
+					if key < at {
+						lefts += 1
+					} else {
+						rights += 1
+					}
+				}
+
+				for _, side := range []struct {
+					tree  *Bptree
+					count int
+					less  bool
+				}{{left, lefts, true}, {right, rights, false}} {
+					err = side.tree.Verify()
+					if err != nil {
+						t.Errorf("degree %d, split at %d: invalid tree: %v", maxDegree, at, err)
+						t.FailNow()
+					}
+
+					if side.tree.Len() != side.count {
+						t.Errorf("degree %d, split at %d: %d elements, expected %d", maxDegree, at, side.tree.Len(), side.count)
+						t.FailNow()
+					}
+
+					for _, key := range treeKeys(side.tree) {
+						if (key < at) != side.less {
+							t.Errorf("degree %d, split at %d: %d is on the wrong side", maxDegree, at, key)
+							t.FailNow()
+						}
+					}
+				}
+
+				if tree.Len() != 0 {
+					t.Errorf("split tree must be empty, but has %d elements", tree.Len())
+					t.FailNow()
+				}
+
+				joined, err := Join(left, right)
+				if err != nil {
+					t.Errorf("while joining: %v", err)
+					t.FailNow()
+				}
+
+				err = joined.Verify()
+				if err != nil {
+					t.Errorf("degree %d, joined at %d: invalid tree: %v", maxDegree, at, err)
+					t.FailNow()
+				}
+
+				if !joined.Equal(whole, nil) {
+					t.Errorf("degree %d, joined at %d: contents differ from the original", maxDegree, at)
+					t.FailNow()
+				}
+
+				if joinedHash, _ := joined.RootHash(); !bytes.Equal(joinedHash, hash) {
+					t.Errorf("degree %d, joined at %d: root hash differs from the original", maxDegree, at)
+					t.FailNow()
+				}
+			}
+		}
+	}
+}
+
+func TestJoinTrees(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	// trees of very different heights
+	for _, sizes := range [][2]int{{0, 0}, {1, 500}, {500, 1}, {3, 2000}, {2000, 3}, {700, 900}} {
+		a, _ := NewBptree(3, _maxDepth, false)
+		b, _ := NewBptree(3, _maxDepth, false)
+
+		for i := 0; i < sizes[0]; i++ {
+			a.Insert(&testElem{i})
+		}
+
+		for i := 0; i < sizes[1]; i++ {
+			b.Insert(&testElem{sizes[0] + i})
+		}
+
+		// shake shapes a bit
+		for i := 0; i < (sizes[0]+sizes[1])/10; i++ {
+			key := r.Intn(sizes[0] + sizes[1])
+
+			if key < sizes[0] {
+				a.Remove(testKey(key))
+				a.Insert(&testElem{key})
+			} else {
+				b.Remove(testKey(key))
+				b.Insert(&testElem{key})
+			}
+		}
+
+		joined, err := Join(a, b)
+		if err != nil {
+			t.Errorf("while joining %v: %v", sizes, err)
+			t.FailNow()
+		}
+
+		err = joined.Verify()
+		if err != nil {
+			t.Errorf("joining %v: invalid tree: %v", sizes, err)
+			t.FailNow()
+		}
+
+		keys := treeKeys(joined)
+		if len(keys) != sizes[0]+sizes[1] {
+			t.Errorf("joining %v: %d elements", sizes, len(keys))
+			t.FailNow()
+		}
+
+		for i, key := range keys {
+			if key != i {
+				t.Errorf("joining %v: %d at %d", sizes, key, i)
+				t.FailNow()
+			}
+		}
+
+		if a.Len() != 0 || b.Len() != 0 {
+			t.Errorf("joined trees must be empty")
+			t.FailNow()
+		}
+	}
+}
+
+func TestSplitAtBookkeeping(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	tree.SetCapacity(Capacity{MaxElems: 100, Policy: EVICT_LEAST_RECENTLY_USED})
+
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			tree.InsertWithTTL(&testElem{i}, time.Minute)
+		} else {
+			tree.Insert(&testElem{i})
+		}
+	}
+
+	left, right, err := tree.SplitAt(testKey(30))
+	if err != nil {
+		t.Errorf("while splitting: %v", err)
+		t.FailNow()
+	}
+
+	if left.Len() != 30 || right.Len() != 70 {
+		t.Errorf("split into %d and %d elements, expected 30 and 70", left.Len(), right.Len())
+		t.FailNow()
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+
+	if n := left.ExpireNow(); n != 3 {
+		t.Errorf("%d expired on the left, expected 3", n)
+		t.FailNow()
+	}
+
+	if n := right.ExpireNow(); n != 7 {
+		t.Errorf("%d expired on the right, expected 7", n)
+		t.FailNow()
+	}
+
+	joined, err := Join(left, right)
+	if err != nil {
+		t.Errorf("while joining: %v", err)
+		t.FailNow()
+	}
+
+	// capacity is kept, so the least recently used goes out
+	joined.Search(testKey(1))
+
+	for i := 100; i < 110; i++ {
+		joined.Insert(&testElem{i})
+	}
+
+	if joined.Len() != 100 {
+		t.Errorf("joined tree has %d elements, expected 100", joined.Len())
+		t.FailNow()
+	}
+
+	if _, ok, _ := joined.Search(testKey(1)); !ok {
+		t.Errorf("recently used element must not be evicted")
+		t.FailNow()
+	}
+
+	err = joined.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestJoinRejected(t *testing.T) {
+	a, _ := NewBptree(4, _maxDepth, false)
+	b, _ := NewBptree(4, _maxDepth, false)
+
+	for i := 0; i < 10; i++ {
+		a.Insert(&testElem{i})
+		b.Insert(&testElem{i + 9})
+	}
+
+	_, err := Join(a, b)
+	if !errors.Is(err, ERR_OVERLAPPED) {
+		t.Errorf("joining trees sharing a key must fail with ERR_OVERLAPPED, but %v", err)
+		t.FailNow()
+	}
+
+	_, err = Join(b, a)
+	if err == nil {
+		t.Errorf("joining trees out of order must fail")
+		t.FailNow()
+	}
+
+	_, err = Join(a, a)
+	if err == nil {
+		t.Errorf("joining a tree with itself must fail")
+		t.FailNow()
+	}
+
+	c, _ := NewBptree(7, _maxDepth, false)
+	c.Insert(&testElem{100})
+
+	_, err = Join(a, c)
+	if err == nil {
+		t.Errorf("joining trees of different degrees must fail")
+		t.FailNow()
+	}
+
+	// rejected trees are kept as they were
+	if a.Len() != 10 || b.Len() != 10 || c.Len() != 1 {
+		t.Errorf("rejected trees must be kept")
+		t.FailNow()
+	}
+
+	err = a.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestSplitAtJoinInherit(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	var removed int
+
+	tree.AfterRemove(func(elem Elem) error {
+		removed += 1
+		return nil
+	})
+
+	observer := &testObserver{ops: make(map[string]int), events: make(map[StructuralChange]int)}
+	tree.SetObserver(observer)
+
+	expired := make(chan Elems, 10)
+	tree.OnExpire(func(elems Elems) {
+		expired <- elems
+	})
+
+	tree.StartReaper(time.Millisecond)
+	defer tree.StopReaper()
+
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	left, right, err := tree.SplitAt(testKey(50))
+	if err != nil {
+		t.Errorf("while splitting: %v", err)
+		t.FailNow()
+	}
+
+	defer left.StopReaper()
+	defer right.StopReaper()
+
+	left.Remove(testKey(0))
+	right.Remove(testKey(99))
+
+	if removed != 2 {
+		t.Errorf("remove hook must run on both sides, but %d", removed)
+		t.FailNow()
+	}
+
+	// hooks added to one must not run on the other
+	right.AfterRemove(func(elem Elem) error {
+		t.Errorf("hook of right must not run for %v", elem)
+		return nil
+	})
+
+	left.Remove(testKey(1))
+
+	// reaper keeps running on the sides
+	left.InsertWithTTL(&testElem{0}, time.Millisecond)
+
+	select {
+	case elems := <-expired:
+		if len(elems) != 1 || elems[0].Key() != testKey(0) {
+			t.Errorf("%v must be expired", elems)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timeouted")
+		t.FailNow()
+	}
+
+	joined, err := Join(left, right)
+	if err != nil {
+		t.Errorf("while joining: %v", err)
+		t.FailNow()
+	}
+
+	defer joined.StopReaper()
+
+	joined.Search(testKey(10))
+
+	observer.lock.Lock()
+	defer observer.lock.Unlock()
+
+	if observer.ops["split"] != 1 || observer.ops["search"] != 1 {
+		t.Errorf("observer must be carried over, but %v", observer.ops)
+		t.FailNow()
+	}
+}
+
+func TestJoinSameMonoid(t *testing.T) {
+	newTree := func(monoid Monoid) *Bptree {
+		tree, err := New(WithDegree(4), WithAggregate(monoid))
+		if err != nil {
+			t.Errorf("while creating bptree: %v", err)
+			t.FailNow()
+		}
+
+		return tree
+	}
+
+	// built independently with the same monoid
+	a, b := newTree(sumMonoid), newTree(sumMonoid)
+
+	for i := 0; i < 10; i++ {
+		a.Insert(&testElem{i})
+		b.Insert(&testElem{i + 10})
+	}
+
+	joined, err := Join(a, b)
+	if err != nil {
+		t.Errorf("trees of the same monoid must be joined, but %v", err)
+		t.FailNow()
+	}
+
+	if agg, _ := joined.Aggregate(nil, nil); agg != 190 {
+		t.Errorf("sum must be 190, but %v", agg)
+		t.FailNow()
+	}
+
+	other := sumMonoid
+	other.Identity = 1
+
+	_, err = Join(joined, newTree(other))
+	if err == nil {
+		t.Errorf("trees of different monoids must not be joined")
+		t.FailNow()
+	}
+}
+
+func TestRecognizableSplitAt(t *testing.T) {
+	rbptree, err := NewRecognizableBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	rbptree.Bptree.Insert(&testElem{0})
+	rbptree.Bptree.Insert(&testElem{1})
+
+	notify := rbptree.AddWatch()
+
+	_, _, err = rbptree.SplitAt(testKey(1))
+	if err != nil {
+		t.Errorf("while splitting: %v", err)
+		t.FailNow()
+	}
+
+	select {
+	case event := <-notify:
+		if event != EVENT_MODIFIED {
+			t.Errorf("split must notify modification, but %d", event)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timeouted")
+		t.FailNow()
+	}
+}
+
+func TestSplitAtJoinLogarithmic(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	// sizes are asked only for elements of nodes along the paths
+	var sized int
+
+	tree.SetCapacity(Capacity{MaxBytes: 1 << 30, Policy: EVICT_LOWEST_KEY, SizeOf: func(elem Elem) int {
+		sized += 1
+		return elem.(*testElem).val % 7
+	}})
+
+	var bytes int64
+
+	for i := 0; i < 10000; i++ {
+		if i%3 == 0 {
+			tree.InsertWithTTL(&testElem{i}, time.Minute)
+		} else {
+			tree.Insert(&testElem{i})
+		}
+
+		bytes += int64(i % 7)
+	}
+
+	sized = 0
+
+	left, right, err := tree.SplitAt(testKey(5000))
+	if err != nil {
+		t.Errorf("while splitting: %v", err)
+		t.FailNow()
+	}
+
+	if sized > 500 {
+		t.Errorf("splitting must not visit every element, but sized %d", sized)
+		t.FailNow()
+	}
+
+	if left.Len() != 5000 || right.Len() != 5000 || left.bytes+right.bytes != bytes ||
+		left.expiring != 1667 || right.expiring != 1667 {
+		t.Errorf("bookkeeping is wrong: %d/%d elements, %d bytes, %d/%d expiring",
+			left.Len(), right.Len(), left.bytes+right.bytes, left.expiring, right.expiring)
+		t.FailNow()
+	}
+
+	sized = 0
+
+	joined, err := Join(left, right)
+	if err != nil {
+		t.Errorf("while joining: %v", err)
+		t.FailNow()
+	}
+
+	if sized > 500 {
+		t.Errorf("joining must not visit every element, but sized %d", sized)
+		t.FailNow()
+	}
+
+	if joined.Len() != 10000 || joined.bytes != bytes || joined.expiring != 3334 {
+		t.Errorf("bookkeeping is wrong: %d elements, %d bytes, %d expiring", joined.Len(), joined.bytes, joined.expiring)
+		t.FailNow()
+	}
+
+	err = joined.Verify()
+	if err != nil {
+		t.Errorf("invalid tree: %v", err)
+		t.FailNow()
+	}
+
+	clock.Advance(time.Hour)
+
+	if n := joined.ExpireNow(); n != 3334 {
+		t.Errorf("%d expired, expected 3334", n)
+		t.FailNow()
+	}
+}
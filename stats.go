@@ -0,0 +1,90 @@
+package bptree
+
+// Stats is a snapshot of the shape of a tree.
+type Stats struct {
+	Height int // number of levels, zero for empty tree
+	Elems  int // including expired ones not removed yet
+
+	LeafNodes     int
+	InternalNodes int
+	LevelNodes    []int // number of nodes of each level from root
+
+	// fill is number of children over capacity of a node. Root is not
+	// counted unless it is the only node, as it is allowed to be underfull.
+	AvgFill float64
+	MinFill float64
+
+	Splits          uint64
+	Merges          uint64
+	Redistributions uint64
+}
+
+// Stats walks every node of the tree, so it takes time in proportion to
+// number of nodes. It returns zero stats for uninitialized tree.
+func (tree *Bptree) Stats() Stats {
+	if !tree.initialized {
+		return Stats{}
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	stats := Stats{
+		Elems:           tree.count,
+		Splits:          tree.splits,
+		Merges:          tree.merges,
+		Redistributions: tree.redistributions,
+	}
+
+	if tree.root == nil {
+		return stats
+	}
+
+	var sumFill float64
+	var filled int
+
+	// every level is linked from its left-most node
+	for first := tree.root; first != nil; {
+		n := 0
+
+		for node := first; node != nil; node = node.next {
+			n += 1
+
+			if node.isInternal {
+				stats.InternalNodes += 1
+			} else {
+				stats.LeafNodes += 1
+			}
+
+			if node == tree.root && node.isInternal {
+				continue
+			}
+
+			fill := float64(len(node.children)) / float64(tree.maxChildren(node.isInternal))
+
+			if filled == 0 || fill < stats.MinFill {
+				stats.MinFill = fill
+			}
+
+			sumFill += fill
+			filled += 1
+		}
+
+		stats.LevelNodes = append(stats.LevelNodes, n)
+
+		if !first.isInternal {
+			break
+		}
+
+		first = first.children[0].(*indexNode)
+	}
+
+	stats.Height = len(stats.LevelNodes)
+
+	if filled > 0 {
+		stats.AvgFill = sumFill / float64(filled)
+	}
+
+	return stats
+}
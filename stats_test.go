@@ -0,0 +1,71 @@
+package bptree
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	stats := tree.Stats()
+	if stats.Height != 0 || stats.Elems != 0 || stats.LeafNodes != 0 {
+		t.Errorf("empty tree must have no stats: %+v", stats)
+	}
+
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	stats = tree.Stats()
+
+	if stats.Elems != 1000 || stats.Height != tree.root.depthToLeaf+1 || stats.Height != len(stats.LevelNodes) {
+		t.Errorf("unexpected height or count: %+v", stats)
+	}
+
+	if stats.LevelNodes[0] != 1 || stats.LevelNodes[stats.Height-1] != stats.LeafNodes {
+		t.Errorf("unexpected nodes of levels: %+v", stats)
+	}
+
+	var sum int
+	for _, n := range stats.LevelNodes {
+		sum += n
+	}
+
+	if sum != stats.LeafNodes+stats.InternalNodes {
+		t.Errorf("nodes of levels must sum up to %d, but %d", stats.LeafNodes+stats.InternalNodes, sum)
+	}
+
+	// leaves hold 3 at most and split into 1 and 2
+	if stats.MinFill < 1.0/3 || stats.AvgFill < stats.MinFill || stats.AvgFill > 1 {
+		t.Errorf("unexpected fill: %+v", stats)
+	}
+
+	// a node comes from a split, or is a root grown over the first one
+	if stats.Splits != uint64(stats.LeafNodes+stats.InternalNodes-stats.Height) || stats.Merges != 0 {
+		t.Errorf("nodes must be made by split: %+v", stats)
+	}
+
+	for i := 0; i < 1000; i++ {
+		tree.Remove(testKey(i))
+	}
+
+	stats = tree.Stats()
+
+	if stats.Elems != 0 || stats.Height != 1 || stats.Merges == 0 || stats.Redistributions == 0 {
+		t.Errorf("emptied tree must have merged: %+v", stats)
+	}
+}
+
+func TestStatsNotInitialized(t *testing.T) {
+	var tree Bptree
+
+	stats := tree.Stats()
+	if stats.Height != 0 || stats.Elems != 0 || stats.LevelNodes != nil {
+		t.Errorf("uninitialized tree must have zero stats, but %+v", stats)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,260 @@
+package bptree
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock tells the current time to expiration of elements. It is replaceable
+// for deterministic tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ExpireHook is called with elements removed because their time to live
+// passed. Like Hook, it runs while the tree is write locked.
+type ExpireHook func(elems Elems)
+
+// now returns current unix nano to check expiration, or zero when no element
+// could expire
+func (tree *Bptree) now() int64 {
+	if tree.expiring == 0 {
+		return 0
+	}
+
+	return tree.clock.Now().UnixNano()
+}
+
+// visible unwraps elem and reports whether it is alive at now
+func (tree *Bptree) visible(elem Elem, now int64) (Elem, bool) {
+	if e, ok := elem.(*entry); ok {
+		if now != 0 && e.deadline != 0 && e.deadline <= now {
+			return nil, false
+		}
+
+		return e.Elem, true
+	}
+
+	return elem, true
+}
+
+// visibleMatch returns a match for findWhere accepting alive elements only
+func (tree *Bptree) visibleMatch(now int64) func(Elem) bool {
+	if now == 0 {
+		return nil
+	}
+
+	return func(elem Elem) bool {
+		_, ok := tree.visible(elem, now)
+		return ok
+	}
+}
+
+func (tree *Bptree) SetClock(clock Clock) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	tree.clock = clock
+}
+
+// InsertWithTTL inserts elem which becomes invisible once ttl has passed and
+// is removed from the tree by ExpireNow or the reaper.
+func (tree *Bptree) InsertWithTTL(elem Elem, ttl time.Duration) (err error) {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	if ttl <= 0 {
+		return errors.New("ttl must to have a positive value")
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.observer != nil {
+		defer tree.observe(OP_INSERT, elem.Key(), time.Now(), &err)
+	}
+
+	defer tree.mutated("insert", &err)
+
+	err = tree.writable()
+	if err != nil {
+		return err
+	}
+
+	stored := &entry{
+		Elem:     elem,
+		deadline: tree.clock.Now().Add(ttl).UnixNano(),
+	}
+
+	err = tree.insertWithHooks(elem, stored)
+
+	return keyError("insert", elem.Key(), err)
+}
+
+func (tree *Bptree) OnExpire(hook ExpireHook) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.expireHooks = append(tree.expireHooks, hook)
+}
+
+// ExpireNow removes every expired element and returns how many are removed.
+// Nothing is removed from a poisoned tree.
+func (tree *Bptree) ExpireNow() int {
+	if !tree.initialized {
+		return 0
+	}
+
+	// write lock
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	var err error
+
+	if tree.observer != nil {
+		defer tree.observe(OP_EXPIRE, nil, time.Now(), &err)
+	}
+
+	defer tree.mutated("expire", &err)
+
+	if tree.writable() != nil {
+		return 0
+	}
+
+	return tree.expire(tree.clock.Now().UnixNano())
+}
+
+// expire removes elements expired at now, caller must hold tree lock
+func (tree *Bptree) expire(now int64) int {
+	if tree.expiring == 0 || now < tree.nextDeadline {
+		return 0
+	}
+
+	var expired []*entry
+	var next int64
+
+	for node := tree.firstLeaf(); node != nil; node = node.next {
+		for _, child := range node.children {
+			e, ok := child.(*entry)
+			if !ok || e.deadline == 0 {
+				continue
+			}
+
+			if e.deadline <= now {
+				expired = append(expired, e)
+			} else if next == 0 || e.deadline < next {
+				next = e.deadline
+			}
+		}
+	}
+
+	elems := make(Elems, 0, len(expired))
+
+	for _, e := range expired {
+		paths, idx, err := tree.findWhere(e.Key(), func(elem Elem) bool {
+			return elem == Elem(e)
+		})
+		if err != nil {
+			continue
+		}
+
+		elems = append(elems, e.Elem)
+
+		err = tree.removeAt(paths, idx)
+		if err != nil {
+			break
+		}
+	}
+
+	tree.nextDeadline = next
+	tree.notifyExpired(elems)
+
+	return len(elems)
+}
+
+// expireKey removes expired elements of key, caller must hold tree lock
+func (tree *Bptree) expireKey(key Key) {
+	now := tree.clock.Now().UnixNano()
+
+	var elems Elems
+
+	for {
+		paths, idx, err := tree.findWhere(key, func(elem Elem) bool {
+			_, ok := tree.visible(elem, now)
+			return !ok
+		})
+		if err != nil {
+			break
+		}
+
+		elems = append(elems, unwrapElem(paths[len(paths)-1].children[idx]))
+
+		err = tree.removeAt(paths, idx)
+		if err != nil {
+			break
+		}
+	}
+
+	tree.notifyExpired(elems)
+}
+
+func (tree *Bptree) notifyExpired(elems Elems) {
+	if len(elems) == 0 {
+		return
+	}
+
+	tree.hooks.notify(tree.hooks.afterRemove, elems)
+
+	for _, hook := range tree.expireHooks {
+		hook(elems)
+	}
+}
+
+// StartReaper removes expired elements every interval in background until
+// StopReaper is called.
+func (tree *Bptree) StartReaper(interval time.Duration) {
+	tree.StopReaper()
+
+	stop := make(chan struct{})
+
+	tree.lock.Lock()
+	tree.reaperStop = stop
+	tree.reaperInterval = interval
+	tree.lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				tree.ExpireNow()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (tree *Bptree) StopReaper() {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.reaperStop != nil {
+		close(tree.reaperStop)
+		tree.reaperStop = nil
+	}
+}
@@ -0,0 +1,198 @@
+package bptree
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now  time.Time
+	lock sync.Mutex
+}
+
+func (clock *testClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	return clock.now
+}
+
+func (clock *testClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	clock.now = clock.now.Add(d)
+}
+
+func TestTTL(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	// odd elements expire after a minute
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			err = tree.Insert(&testElem{i})
+		} else {
+			err = tree.InsertWithTTL(&testElem{i}, time.Minute)
+		}
+
+		if err != nil {
+			t.Errorf("while inserting %d: %v", i, err)
+			t.FailNow()
+		}
+	}
+
+	_, ok, _ := tree.SearchElem(testKey(51))
+	if !ok {
+		t.Errorf("element must be alive before ttl")
+		t.FailNow()
+	}
+
+	clock.Advance(time.Minute)
+
+	// invisible even before reaped
+	_, ok, _ = tree.SearchElem(testKey(51))
+	if ok {
+		t.Errorf("expired element must be invisible")
+	}
+
+	elem, equal, err := tree.SearchElemNearby(testKey(51), ToRight)
+	if err != nil || equal || elem.(*testElem).val != 52 {
+		t.Errorf("nearby to right must skip expired, but %v %v %v", elem, equal, err)
+	}
+
+	elem, equal, err = tree.SearchElemNearby(testKey(51), ToLeft)
+	if err != nil || equal || elem.(*testElem).val != 50 {
+		t.Errorf("nearby to left must skip expired, but %v %v %v", elem, equal, err)
+	}
+
+	res, _, _ := tree.Search(testKey(50))
+
+	elems, n := res.ElemRange(3)
+	if n != 4 || elems[3].(*testElem).val != 56 {
+		t.Errorf("range must skip expired, but %v", elems)
+	}
+
+	elems, n = res.ElemRangeTo(testKey(40), ToLeft, 100)
+	if n != 6 || elems[0].(*testElem).val != 40 {
+		t.Errorf("range to key must skip expired, but %v", elems)
+	}
+
+	elem, ok = res.ElemAt(-2)
+	if !ok || elem.(*testElem).val != 46 {
+		t.Errorf("elem at offset must skip expired, but %v", elem)
+	}
+
+	// inserting a key taken by an expired element
+	err = tree.Insert(&testElem{51})
+	if err != nil {
+		t.Errorf("expired element must not block insertion: %v", err)
+	}
+
+	n = tree.ExpireNow()
+	if n != 49 {
+		t.Errorf("49 elements must be expired, but %d", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		_, ok, _ = tree.SearchElem(testKey(i))
+		if ok != (i%2 == 0 || i == 51) {
+			t.Errorf("unexpected existence of %d: %v", i, ok)
+		}
+	}
+
+	if tree.ExpireNow() != 0 {
+		t.Errorf("nothing must be left to expire")
+	}
+}
+
+func TestTTLReaperNotifiesWatchers(t *testing.T) {
+	rbptree, err := NewRecognizableBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating recognizable bptree: %v", err)
+		t.FailNow()
+	}
+
+	notify := rbptree.AddWatch()
+
+	err = rbptree.InsertWithTTL(&testElem{1}, time.Millisecond)
+	if err != nil {
+		t.Errorf("while inserting: %v", err)
+		t.FailNow()
+	}
+
+	rbptree.StartReaper(time.Millisecond)
+	defer rbptree.StopReaper()
+
+	timeout := time.After(time.Second)
+	events := make(map[int]bool)
+
+	for !events[EVENT_EXPIRED] {
+		select {
+		case event := <-notify:
+			events[event] = true
+		case <-timeout:
+			t.Errorf("timeouted, received %v", events)
+			t.FailNow()
+		}
+	}
+
+	_, ok, _ := rbptree.SearchElem(testKey(1))
+	if ok {
+		t.Errorf("reaped element must not be found")
+	}
+}
+
+func TestTTLRunsRemoveHooks(t *testing.T) {
+	tree, err := NewBptree(4, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	clock := &testClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+
+	// derived count kept by hooks
+	count := 0
+
+	tree.AfterInsert(func(elem Elem) error {
+		count += 1
+		return nil
+	})
+
+	tree.AfterRemove(func(elem Elem) error {
+		count -= 1
+		return nil
+	})
+
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			tree.InsertWithTTL(&testElem{i}, time.Minute)
+		} else {
+			tree.Insert(&testElem{i})
+		}
+	}
+
+	clock.Advance(time.Hour)
+
+	// expired one of the same key is removed on insertion
+	tree.Insert(&testElem{0})
+
+	if n := tree.ExpireNow(); n != 9 {
+		t.Errorf("%d expired, expected 9", n)
+		t.FailNow()
+	}
+
+	if count != tree.Len() {
+		t.Errorf("count kept by hooks is %d, but tree has %d", count, tree.Len())
+		t.FailNow()
+	}
+}
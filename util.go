@@ -5,6 +5,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+)
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
 )
 
 func PrintTreeToWriter(tree *Bptree, w io.Writer) error {
@@ -43,6 +49,85 @@ func PrintTree(tree *Bptree) error {
 	return PrintTreeToWriter(tree, os.Stdout)
 }
 
+// PrintNodesToWriter prints every node indented by its depth from root, with
+// its id, separators or keys of elements, number of children and fill.
+// Underfull nodes are marked, and colored red with ANSI escapes if highlight
+// is set. Node ids are positions in levels like WriteJSON.
+func PrintNodesToWriter(tree *Bptree, w io.Writer, highlight bool) error {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return printNodes(tree, w, highlight)
+}
+
+// printNodes is PrintNodesToWriter without locking
+func printNodes(tree *Bptree, w io.Writer, highlight bool) error {
+	if tree.root == nil {
+		return fmt.Errorf("tree is nil")
+	}
+
+	ids := make(map[*indexNode]string)
+
+	for depth, nodes := range tree.levels() {
+		for i, node := range nodes {
+			ids[node] = fmt.Sprintf("n%d_%d", depth, i)
+		}
+	}
+
+	var walk func(node *indexNode, depth int) error
+
+	walk = func(node *indexNode, depth int) error {
+		keys := make([]string, len(node.children))
+		for i, child := range node.children {
+			keys[i] = fmt.Sprintf("%v", child.Key())
+		}
+
+		kind := "leaf"
+		if node.isInternal {
+			kind = "internal"
+		}
+
+		capacity := tree.maxChildren(node.isInternal)
+
+		line := fmt.Sprintf("%s%s %s depth %d, %d/%d children (%d%%) [%s]",
+			strings.Repeat("  ", depth), ids[node], kind, depth,
+			len(node.children), capacity, len(node.children)*100/capacity, strings.Join(keys, " "))
+
+		if node != tree.root && len(node.children) < tree.minChildren(node.isInternal) {
+			line += " underfull"
+
+			if highlight {
+				line = ansiRed + line + ansiReset
+			}
+		}
+
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+
+		if !node.isInternal {
+			return nil
+		}
+
+		for _, child := range node.children {
+			err = walk(child.(*indexNode), depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return walk(tree.root, 0)
+}
+
+// for debug
+func PrintNodes(tree *Bptree) error {
+	return PrintNodesToWriter(tree, os.Stdout, true)
+}
+
 func printTreeToString(tree *Bptree) (s string, err error) {
 	buf := new(bytes.Buffer)
 
@@ -0,0 +1,47 @@
+package bptree
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintNodes(t *testing.T) {
+	tree, err := NewBptree(3, _maxDepth, false)
+	if err != nil {
+		t.Errorf("while creating bptree: %v", err)
+		t.FailNow()
+	}
+
+	for i := 1; i <= 3; i++ {
+		tree.Insert(&testElem{i})
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = PrintNodesToWriter(tree, buf, true)
+	if err != nil {
+		t.Errorf("while printing: %v", err)
+		t.FailNow()
+	}
+
+	expected := `n0_0 internal depth 0, 2/3 children (66%) [1 2]
+  n1_0 leaf depth 1, 1/2 children (50%) [1]
+  n1_1 leaf depth 1, 2/2 children (100%) [2 3]
+`
+
+	if buf.String() != expected {
+		t.Errorf("unexpected nodes:\n%s", buf.String())
+	}
+
+	// underfull leaf is highlighted
+	tree.firstLeaf().children = nil
+
+	buf.Reset()
+	PrintNodesToWriter(tree, buf, true)
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasPrefix(lines[1], ansiRed) || !strings.Contains(lines[1], "underfull") || strings.Contains(lines[2], "underfull") {
+		t.Errorf("underfull node must be highlighted:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,294 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// errors
+	ERR_INVARIANT_VIOLATED = errors.New("tree invariant violated")
+)
+
+// Verify checks structural invariants of the tree: order of keys within and
+// across leaves, fill of nodes, uniform depth of leaves, prev/next links of
+// every level, separators of internal nodes, cached edge leaves, hashes,
+// aggregates and max ends of sub-trees and bookkeeping counters.
+func (tree *Bptree) Verify() error {
+	if !tree.initialized {
+		return ERR_NOT_INITIALIZED
+	}
+
+	// read lock
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.verify()
+}
+
+// SetVerifyOnMutation makes every mutation verify the tree afterwards and
+// fail with ErrCorrupted on a violation. It is meant for tests.
+func (tree *Bptree) SetVerifyOnMutation(enabled bool) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	tree.verifyOnMutation = enabled
+}
+
+type verifier struct {
+	tree *Bptree
+
+	levels [][]*indexNode // nodes of each depth from root, in order
+
+	count    int
+	expiring int
+	bytes    int64
+}
+
+func (v *verifier) fail(path, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s: %s", ERR_INVARIANT_VIOLATED, path, fmt.Sprintf(format, args...))
+}
+
+// verify is Verify without locking, caller must hold tree lock
+func (tree *Bptree) verify() error {
+	root := tree.root
+	if root == nil {
+		if tree.count != 0 {
+			return fmt.Errorf("%w: empty tree counts %d elements", ERR_INVARIANT_VIOLATED, tree.count)
+		}
+
+		return nil
+	}
+
+	v := &verifier{tree: tree}
+
+	if root.isInternal && len(root.children) < 2 {
+		return v.fail("root", "internal root has %d children", len(root.children))
+	}
+
+	err := v.node(root, "root", 0, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = v.links()
+	if err != nil {
+		return err
+	}
+
+	leaves := v.levels[len(v.levels)-1]
+
+	if tree.head != leaves[0] || tree.tail != leaves[len(leaves)-1] {
+		return v.fail("root", "cached head or tail is not an edge leaf")
+	}
+
+	if v.count != tree.count {
+		return v.fail("root", "%d elements stored, but counted %d", v.count, tree.count)
+	}
+
+	if v.expiring != tree.expiring {
+		return v.fail("root", "%d elements expiring, but counted %d", v.expiring, tree.expiring)
+	}
+
+	if tree.capacity.SizeOf != nil && v.bytes != tree.bytes {
+		return v.fail("root", "%d bytes stored, but counted %d", v.bytes, tree.bytes)
+	}
+
+	return nil
+}
+
+// node verifies sub-tree whose keys must be in [lo, hi]
+func (v *verifier) node(node *indexNode, path string, level int, lo, hi Key) error {
+	tree := v.tree
+
+	if len(v.levels) <= level {
+		v.levels = append(v.levels, nil)
+	}
+
+	v.levels[level] = append(v.levels[level], node)
+
+	children := node.children
+	isRoot := level == 0
+
+	if len(children) > tree.maxChildren(node.isInternal) {
+		return v.fail(path, "overflowed with %d children", len(children))
+	}
+
+	if !isRoot && len(children) < tree.minChildren(node.isInternal) {
+		return v.fail(path, "underflowed with %d children", len(children))
+	}
+
+	if !isRoot && len(children) == 0 {
+		return v.fail(path, "empty node")
+	}
+
+	if len(children) > 0 && node._tmpKey != nil {
+		return v.fail(path, "stale temporary key %v", node._tmpKey)
+	}
+
+	if isRoot && (node.prev != nil || node.next != nil) {
+		return v.fail(path, "root has siblings")
+	}
+
+	for i, child := range children {
+		key := child.Key()
+
+		if lo != nil && key.CompareTo(lo) == Less {
+			return v.fail(path, "key %v of child %d is less than separator %v", key, i, lo)
+		}
+
+		if hi != nil {
+			cond := key.CompareTo(hi)
+
+			if cond == Greater || (cond == Equal && !tree.allowOverlap) {
+				return v.fail(path, "key %v of child %d is not less than next separator %v", key, i, hi)
+			}
+		}
+
+		if i > 0 {
+			cond := children[i-1].Key().CompareTo(key)
+
+			if cond == Greater || (cond == Equal && !tree.allowOverlap) {
+				return v.fail(path, "keys of child %d and %d are out of order", i-1, i)
+			}
+		}
+
+		childNode, isNode := child.(*indexNode)
+
+		if !node.isInternal {
+			if isNode {
+				return v.fail(path, "leaf has an index node as child %d", i)
+			}
+
+			v.count += 1
+
+			if e, ok := child.(*entry); ok && e.deadline != 0 {
+				v.expiring += 1
+			}
+
+			if tree.capacity.SizeOf != nil {
+				v.bytes += int64(tree.capacity.SizeOf(unwrapElem(child)))
+			}
+
+			continue
+		}
+
+		if !isNode {
+			return v.fail(path, "internal node has an element as child %d", i)
+		}
+
+		if childNode.depthToLeaf != node.depthToLeaf-1 {
+			return v.fail(path, "child %d has depth to leaf %d under %d", i, childNode.depthToLeaf, node.depthToLeaf)
+		}
+
+		if childNode.isInternal != (childNode.depthToLeaf > 0) {
+			return v.fail(path, "child %d is internal(%v) at depth to leaf %d", i, childNode.isInternal, childNode.depthToLeaf)
+		}
+
+		// separator of child is its smallest key, and next one bounds it
+		var childHi Key = hi
+		if i+1 < len(children) {
+			childHi = children[i+1].Key()
+		}
+
+		err := v.node(childNode, fmt.Sprintf("%s/%d", path, i), level+1, key, childHi)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !node.isInternal && node.depthToLeaf != 0 {
+		return v.fail(path, "leaf has depth to leaf %d", node.depthToLeaf)
+	}
+
+	if count, bytes, expiring := tree.totalsOf(node); count != node.count || bytes != node.bytes || expiring != node.expiring {
+		return v.fail(path, "bookkeeping %d/%d/%d doesn't sum up children", node.count, node.bytes, node.expiring)
+	}
+
+	if tree.hashing {
+		err := v.hash(node, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tree.monoids != nil && !reflect.DeepEqual(node.aggs, tree.aggregateOf(node)) {
+		return v.fail(path, "aggregates %v don't combine children", node.aggs)
+	}
+
+	return nil
+}
+
+// hash verifies hash of node sums up its children, and elements of leaf are
+// hashed as they are encoded now
+func (v *verifier) hash(node *indexNode, path string) error {
+	var sum digest
+
+	for i, child := range node.children {
+		switch c := child.(type) {
+		case *indexNode:
+			sum.add(c.hash)
+
+		case *entry:
+			d, err := hashElem(v.tree.codec, c)
+			if err != nil {
+				return v.fail(path, "child %d can't be hashed: %v", i, err)
+			}
+
+			if d != c.digest {
+				return v.fail(path, "child %d doesn't match its hash", i)
+			}
+
+			sum.add(d)
+
+		default:
+			return v.fail(path, "child %d is not hashed", i)
+		}
+	}
+
+	if sum != node.hash {
+		return v.fail(path, "hash doesn't sum up children")
+	}
+
+	return nil
+}
+
+// links verifies prev/next links of every level follow order of the tree
+func (v *verifier) links() error {
+	for level, nodes := range v.levels {
+		path := fmt.Sprintf("level %d", level)
+
+		if nodes[0].prev != nil {
+			return v.fail(path, "first node has prev link")
+		}
+
+		if nodes[len(nodes)-1].next != nil {
+			return v.fail(path, "last node has next link")
+		}
+
+		for i := 0; i+1 < len(nodes); i++ {
+			curr, next := nodes[i], nodes[i+1]
+
+			if curr.next != next {
+				return v.fail(path, "next link of node %d is broken", i)
+			}
+
+			if next.prev != curr {
+				return v.fail(path, "prev link of node %d is broken", i+1)
+			}
+
+			if curr.isInternal || len(curr.children) == 0 || len(next.children) == 0 {
+				continue
+			}
+
+			cond := curr.children[len(curr.children)-1].Key().CompareTo(next.children[0].Key())
+
+			if cond == Greater || (cond == Equal && !v.tree.allowOverlap) {
+				return v.fail(path, "keys of leaf %d and %d are out of order", i, i+1)
+			}
+		}
+	}
+
+	return nil
+}